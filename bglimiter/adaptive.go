@@ -0,0 +1,191 @@
+package bglimiter
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// NewAdaptive returns a new runner that starts with the given limit and adjusts it using alg after every task.
+func NewAdaptive(ctx context.Context, initial int, alg LimitAlgorithm) *BackgroundLimiter {
+	if initial <= 0 {
+		initial = 1
+	}
+
+	return NewWithOptions(ctx, Options{Limit: initial, Algorithm: alg})
+}
+
+// Sample is a finished task passed to LimitAlgorithm.Update.
+type Sample struct {
+	// Latency is how long the task ran for.
+	Latency time.Duration
+	// InFlight is the number of running tasks, including this one.
+	InFlight int
+	// Failed is true if the task returned an error or timed out.
+	Failed bool
+}
+
+// LimitAlgorithm computes the new limit of an adaptive BackgroundLimiter.
+// Update is called under the limiter's lock so it doesn't need to be goroutine-safe,
+// but it must not call back into the limiter.
+type LimitAlgorithm interface {
+	Update(limit int, s Sample) int
+}
+
+// AIMD increases the limit additively while tasks succeed and the limiter is being used,
+// and decreases it multiplicatively on failures or slow tasks.
+type AIMD struct {
+	Min, Max int
+	// Increase defaults to 1.
+	Increase int
+	// Backoff defaults to 0.9.
+	Backoff float64
+	// Timeout, if > 0, counts tasks slower than it as failures.
+	Timeout time.Duration
+}
+
+func (a *AIMD) Update(limit int, s Sample) int {
+	var (
+		inc     = a.Increase
+		backoff = a.Backoff
+	)
+
+	if inc <= 0 {
+		inc = 1
+	}
+
+	if backoff <= 0 || backoff >= 1 {
+		backoff = 0.9
+	}
+
+	switch {
+	case s.Failed || (a.Timeout > 0 && s.Latency > a.Timeout):
+		limit = int(float64(limit) * backoff)
+	case s.InFlight*2 >= limit: // don't grow while the limiter is mostly idle
+		limit += inc
+	}
+
+	return clamp(limit, a.Min, a.Max)
+}
+
+// Vegas estimates the queue size from the ratio between the lowest latency seen and the current one,
+// growing the limit while the queue is small and shrinking it when it gets large.
+type Vegas struct {
+	Min, Max int
+
+	noLoad time.Duration
+}
+
+func (v *Vegas) Update(limit int, s Sample) int {
+	if s.Latency <= 0 {
+		return clamp(limit, v.Min, v.Max)
+	}
+
+	if v.noLoad == 0 || s.Latency < v.noLoad {
+		v.noLoad = s.Latency
+	}
+
+	var (
+		log   = int(math.Max(1, math.Log10(float64(limit))))
+		alpha = 3 * log
+		beta  = 6 * log
+		queue = int(math.Ceil(float64(limit) * (1 - float64(v.noLoad)/float64(s.Latency))))
+	)
+
+	switch {
+	case s.Failed:
+		limit -= log
+	case queue <= alpha && s.InFlight*2 >= limit:
+		limit += log
+	case queue >= beta:
+		limit -= log
+	}
+
+	return clamp(limit, v.Min, v.Max)
+}
+
+// Gradient compares a long term latency average to the current latency,
+// shrinking the limit as latency grows past Tolerance * average and growing it otherwise.
+type Gradient struct {
+	Min, Max int
+	// Tolerance defaults to 1.5.
+	Tolerance float64
+	// Smoothing defaults to 0.2.
+	Smoothing float64
+	// Window is the number of samples the long term average covers, defaults to 600.
+	Window int
+
+	long  float64
+	count int
+	limit float64
+}
+
+func (g *Gradient) Update(limit int, s Sample) int {
+	var (
+		tolerance = g.Tolerance
+		smoothing = g.Smoothing
+		window    = g.Window
+		rtt       = float64(s.Latency)
+	)
+
+	if tolerance < 1 {
+		tolerance = 1.5
+	}
+
+	if smoothing <= 0 || smoothing > 1 {
+		smoothing = 0.2
+	}
+
+	if window <= 0 {
+		window = 600
+	}
+
+	// keep our own float limit so small changes add up, unless someone called SetLimit.
+	if int(g.limit) != limit {
+		g.limit = float64(limit)
+	}
+
+	if rtt <= 0 {
+		return clamp(limit, g.Min, g.Max)
+	}
+
+	if g.count < window {
+		g.count++
+	}
+	g.long += (rtt - g.long) / float64(g.count)
+
+	// recover faster after a latency spike
+	if g.long/rtt > 2 {
+		g.long *= 0.95
+	}
+
+	if s.Failed {
+		g.limit = math.Max(1, g.limit*0.9)
+	} else if s.InFlight*2 >= limit {
+		var (
+			gradient = math.Max(0.5, math.Min(1, tolerance*g.long/rtt))
+			next     = g.limit*gradient + math.Sqrt(g.limit)
+		)
+		g.limit = g.limit*(1-smoothing) + next*smoothing
+	}
+
+	limit = clamp(int(g.limit), g.Min, g.Max)
+	g.limit = math.Max(float64(limit), math.Min(g.limit, float64(limit)+1))
+	return limit
+}
+
+func clamp(limit, min, max int) int {
+	if min < 1 {
+		min = 1
+	}
+
+	if limit < min {
+		return min
+	}
+
+	if max > 0 && limit > max {
+		return max
+	}
+
+	return limit
+}
@@ -0,0 +1,67 @@
+package bglimiter_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/PathDNA/ptk/bglimiter"
+)
+
+func TestAdaptiveAIMD(t *testing.T) {
+	bg := bglimiter.NewAdaptive(context.Background(), 2, &bglimiter.AIMD{Min: 1, Max: 4})
+	defer bg.Close()
+
+	// the limit only grows while the limiter is being used, so keep it busy
+	ok := func(ctx context.Context) error {
+		time.Sleep(time.Millisecond)
+		return nil
+	}
+	for i := 0; i < 10; i++ {
+		chs := make([]<-chan error, 0, 4)
+		for j := 0; j < 4; j++ {
+			chs = append(chs, bg.Add(ok))
+		}
+		for _, ch := range chs {
+			<-ch
+		}
+	}
+
+	if l := bg.Limit(); l != 4 {
+		t.Fatalf("expected the limit to grow to 4, got %d", l)
+	}
+
+	fail := func(ctx context.Context) error { return errors.New("nope") }
+	for i := 0; i < 10; i++ {
+		<-bg.Add(fail)
+	}
+
+	if l := bg.Limit(); l != 1 {
+		t.Fatalf("expected the limit to shrink to 1, got %d", l)
+	}
+}
+
+func TestAdaptiveGradient(t *testing.T) {
+	var (
+		g     bglimiter.Gradient
+		limit = 10
+	)
+
+	for i := 0; i < 100; i++ {
+		limit = g.Update(limit, bglimiter.Sample{Latency: 10 * time.Millisecond, InFlight: limit})
+	}
+
+	grown := limit
+	if grown <= 10 {
+		t.Fatalf("expected the limit to grow, got %d", grown)
+	}
+
+	for i := 0; i < 20; i++ {
+		limit = g.Update(limit, bglimiter.Sample{Latency: 100 * time.Millisecond, InFlight: limit})
+	}
+
+	if limit >= grown {
+		t.Fatalf("expected the limit to shrink below %d, got %d", grown, limit)
+	}
+}
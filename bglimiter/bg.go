@@ -2,8 +2,23 @@ package bglimiter
 
 import (
 	"context"
+	"errors"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/PathDNA/ptk"
+)
+
+var (
+	// ErrQueueFull is returned when a task is submitted while Options.MaxQueue tasks are already waiting for a slot.
+	ErrQueueFull = errors.New("bglimiter: queue is full")
+
+	// ErrLimitReached is returned by TryAdd when there are no free slots.
+	ErrLimitReached = errors.New("bglimiter: limit reached")
+
+	// ErrShutdown is returned for tasks submitted or still waiting for a slot after Shutdown is called.
+	ErrShutdown = errors.New("bglimiter: shutting down")
 )
 
 // New returns a new runner with no limits.
@@ -11,11 +26,56 @@ func New() *BackgroundLimiter { return NewWithContext(context.Background(), 0) }
 
 // NewWithContext returns a new runner with the given parent context and limit, if limit is <= 0 it won't have a limit.
 func NewWithContext(ctx context.Context, limit int) *BackgroundLimiter {
+	return NewWithOptions(ctx, Options{Limit: limit})
+}
+
+// Options are passed to NewWithOptions.
+type Options struct {
+	// Limit is the max number of concurrent tasks, if it's <= 0 there's no limit.
+	Limit int
+
+	// MaxQueue, if > 0, is the max number of tasks waiting for a slot, after that new tasks fail with ErrQueueFull.
+	MaxQueue int
+
+	// TenantWeights is the number of slots a tenant gets per turn when multiple tenants are waiting, defaults to 1.
+	TenantWeights map[string]int
+
+	// Algorithm, if set, adjusts the limit after every task, see NewAdaptive.
+	Algorithm LimitAlgorithm
+
+	// OnError, if set, is called with the error of every failed task, including recovered panics as *ptk.PanicError.
+	OnError func(err error)
+
+	// Metrics, if set, gets notified about every task.
+	Metrics Metrics
+
+	// QueueWaitBuckets are the bucket bounds of Stats().QueueWait, defaults to DefaultQueueWaitBuckets.
+	QueueWaitBuckets []time.Duration
+}
+
+// NewWithOptions returns a new runner with the given parent context and options.
+func NewWithOptions(ctx context.Context, opts Options) *BackgroundLimiter {
 	var bg BackgroundLimiter
 	bg.ctx, bg.cancel = context.WithCancel(ctx)
+	bg.limit = opts.Limit
+	bg.maxQueue = opts.MaxQueue
+	bg.alg = opts.Algorithm
+	bg.onError = opts.OnError
+
+	if opts.QueueWaitBuckets == nil {
+		opts.QueueWaitBuckets = DefaultQueueWaitBuckets
+	}
+	bg.tracker = tracker{
+		stats:   Stats{QueueWait: NewHistogram(opts.QueueWaitBuckets...)},
+		running: map[uint64]*TaskInfo{},
+		m:       opts.Metrics,
+	}
 
-	if limit > 0 {
-		bg.ch = make(chan struct{}, limit)
+	if len(opts.TenantWeights) > 0 {
+		bg.queue.weights = make(map[string]int, len(opts.TenantWeights))
+		for k, v := range opts.TenantWeights {
+			bg.queue.weights[k] = v
+		}
 	}
 
 	return &bg
@@ -23,72 +83,153 @@ func NewWithContext(ctx context.Context, limit int) *BackgroundLimiter {
 
 type BackgroundLimiter struct {
 	wg sync.WaitGroup
-	ch chan struct{}
+
+	mux      sync.Mutex
+	limit    int
+	running  int
+	queue    waitQueue
+	maxQueue int
+	alg      LimitAlgorithm
+	onError  func(err error)
+	closing  bool
+	tracker  tracker
+	finished uint64 // fns that returned
+
+	kmux sync.Mutex
+	keys map[string][]*keyedTask
 
 	ctx    context.Context
 	cancel func()
 }
 
-func (bg *BackgroundLimiter) Add(fn func(ctx context.Context) error) <-chan error {
-	errChan := make(chan error, 2)
-	if !bg.add() {
-		errChan <- context.Canceled
-		close(errChan)
-		return errChan
-	}
+// TaskOptions are passed to AddWithOptions.
+type TaskOptions struct {
+	// Priority, tasks with a higher priority get a slot first.
+	Priority Priority
 
-	go func() { errChan <- fn(bg.ctx) }()
+	// Tenant, waiting tasks of the same priority get slots in turns between tenants, see Options.TenantWeights.
+	Tenant string
 
-	go func() {
-		select {
-		case err := <-errChan: // instead of an extra communication channel, don't judge
-			errChan <- err
-		case <-bg.ctx.Done():
-			errChan <- bg.ctx.Err()
-		}
-		bg.done()
-	}()
+	// Timeout, if > 0, cancels the task's context after it runs for that long.
+	Timeout time.Duration
 
-	return errChan
+	// Name and Labels show up in Tasks() and Metrics.
+	Name   string
+	Labels map[string]string
+}
+
+func (bg *BackgroundLimiter) Add(fn func(ctx context.Context) error) <-chan error {
+	return bg.AddWithOptions(TaskOptions{}, fn)
 }
 
 func (bg *BackgroundLimiter) AddWithTimeout(fn func(ctx context.Context) error, timeout time.Duration) <-chan error {
-	errChan := make(chan error, 2)
+	return bg.AddWithOptions(TaskOptions{Timeout: timeout}, fn)
+}
 
-	if !bg.add() {
-		errChan <- context.Canceled
-		close(errChan)
-		return errChan
-	}
+// AddWithOptions blocks until a slot is available then runs fn in the background,
+// it returns ErrQueueFull right away if Options.MaxQueue tasks are already waiting.
+func (bg *BackgroundLimiter) AddWithOptions(opts TaskOptions, fn func(ctx context.Context) error) <-chan error {
+	return bg.run(context.Background(), opts, false, fn)
+}
 
-	tctx, cancel := context.WithTimeout(bg.ctx, timeout)
+// AddCtx is like Add, but gives up waiting for a slot once ctx is done and returns ctx.Err().
+// ctx only bounds the wait, fn still gets the limiter's context.
+func (bg *BackgroundLimiter) AddCtx(ctx context.Context, fn func(ctx context.Context) error) <-chan error {
+	return bg.run(ctx, TaskOptions{}, false, fn)
+}
 
-	go func() { errChan <- fn(tctx) }()
+// TryAdd is like Add, but returns ErrLimitReached right away if there are no free slots.
+func (bg *BackgroundLimiter) TryAdd(fn func(ctx context.Context) error) <-chan error {
+	return bg.run(context.Background(), TaskOptions{}, true, fn)
+}
 
-	go func() {
-		select {
-		case err := <-errChan: // instead of an extra communication channel, don't judge
-			errChan <- err
-		case <-bg.ctx.Done():
-			errChan <- bg.ctx.Err()
-		case <-tctx.Done():
-			errChan <- tctx.Err()
-		}
-		cancel()
-		bg.done()
-	}()
+func (bg *BackgroundLimiter) Context() context.Context { return bg.ctx }
 
-	return errChan
+// SetLimit changes the max number of concurrent tasks, if limit is <= 0 it won't have a limit.
+// For adaptive limiters, it resets the current limit and the algorithm keeps adjusting it from there.
+// Running tasks are never interrupted, if there are more than the new limit, new tasks wait for them to finish.
+func (bg *BackgroundLimiter) SetLimit(limit int) {
+	bg.mux.Lock()
+	bg.limit = limit
+	bg.notifyWaiters()
+	bg.mux.Unlock()
 }
 
-func (bg *BackgroundLimiter) Context() context.Context { return bg.ctx }
+// Limit returns the current limit, <= 0 means no limit.
+func (bg *BackgroundLimiter) Limit() int {
+	bg.mux.Lock()
+	defer bg.mux.Unlock()
+	return bg.limit
+}
+
+// Running returns the number of tasks holding a slot.
+func (bg *BackgroundLimiter) Running() int {
+	bg.mux.Lock()
+	defer bg.mux.Unlock()
+	return bg.running
+}
+
+// Waiting returns the number of Add calls blocked waiting for a slot.
+func (bg *BackgroundLimiter) Waiting() int {
+	bg.mux.Lock()
+	defer bg.mux.Unlock()
+	return bg.queue.Len()
+}
 
+// Close cancels the limiter's context, running tasks get canceled and blocked Add calls return context.Canceled.
 func (bg *BackgroundLimiter) Close() error {
 	err := bg.ctx.Err()
 	bg.cancel()
 	return err
 }
 
+// ShutdownReport is returned by Shutdown.
+type ShutdownReport struct {
+	// Finished is the number of tasks that returned during the drain.
+	Finished int
+	// Cancelled is the number of tasks that were waiting for a slot and never ran.
+	Cancelled int
+	// Running is the number of tasks still running when ctx was done, their context gets canceled.
+	Running int
+}
+
+// Shutdown stops accepting new tasks, tasks waiting for a slot fail with ErrShutdown,
+// then it waits for the running tasks to return until ctx is done, and finally cancels the limiter.
+// It returns ctx.Err() if ctx was done before all the tasks returned.
+func (bg *BackgroundLimiter) Shutdown(ctx context.Context) (r ShutdownReport, err error) {
+	start := atomic.LoadUint64(&bg.finished)
+
+	bg.mux.Lock()
+	bg.closing = true
+	for bg.queue.Len() > 0 {
+		w := bg.queue.pop()
+		w.err = ErrShutdown
+		close(w.ready)
+		r.Cancelled++
+	}
+	bg.mux.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		bg.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-bg.ctx.Done():
+		err = bg.ctx.Err()
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	r.Finished = int(atomic.LoadUint64(&bg.finished) - start)
+	r.Running = len(bg.Tasks())
+	bg.cancel()
+
+	return
+}
+
 func (bg *BackgroundLimiter) IsCanceled() bool {
 	return bg.ctx.Err() != nil
 }
@@ -111,23 +252,160 @@ func (bg *BackgroundLimiter) WaitWithContext(ctx context.Context) error {
 	}
 }
 
-func (bg *BackgroundLimiter) add() bool {
-	if bg.ch != nil {
-		bg.ch <- struct{}{}
+func (bg *BackgroundLimiter) run(wctx context.Context, opts TaskOptions, try bool, fn func(ctx context.Context) error) <-chan error {
+	var (
+		errChan = make(chan error, 1)
+		ti      = bg.tracker.submitted(opts)
+	)
+
+	if err := bg.add(wctx, opts, try); err != nil {
+		bg.tracker.finished(ti, err)
+		errChan <- err
+		close(errChan)
+		return errChan
+	}
+
+	bg.tracker.started(ti)
+
+	ctx, cancel := bg.ctx, func() {}
+	if opts.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(bg.ctx, opts.Timeout)
+	}
+
+	res := make(chan error, 1)
+
+	go func() {
+		res <- call(ctx, fn)
+		atomic.AddUint64(&bg.finished, 1)
+		bg.tracker.returned(ti)
+	}()
+
+	go func() {
+		var err error
+		select {
+		case err = <-res:
+		case <-ctx.Done(): // covers both bg.ctx and the timeout
+			err = ctx.Err()
+		}
+		cancel()
+		bg.done(time.Since(ti.Started), err)
+		bg.tracker.finished(ti, err)
+
+		if err != nil && bg.onError != nil {
+			bg.onError(err)
+		}
+
+		errChan <- err
+		close(errChan)
+	}()
+
+	return errChan
+}
+
+// call calls fn, converting panics to *ptk.PanicError.
+func call(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = ptk.NewPanicError(v)
+		}
+	}()
+
+	return fn(ctx)
+}
+
+func (bg *BackgroundLimiter) add(wctx context.Context, opts TaskOptions, try bool) error {
+	if bg.IsCanceled() {
+		return context.Canceled
+	}
+
+	bg.mux.Lock()
+	switch {
+	case bg.closing:
+		bg.mux.Unlock()
+		return ErrShutdown
+	case bg.hasSlot():
+		bg.running++
+		bg.mux.Unlock()
+	case try:
+		bg.mux.Unlock()
+		return ErrLimitReached
+	case bg.maxQueue > 0 && bg.queue.Len() >= bg.maxQueue:
+		bg.mux.Unlock()
+		return ErrQueueFull
+	default:
+		w := bg.queue.push(opts.Priority, opts.Tenant)
+		bg.mux.Unlock()
+
+		if err := bg.wait(wctx, w); err != nil {
+			return err
+		}
 	}
 
 	if bg.IsCanceled() {
-		return false
+		bg.release()
+		return context.Canceled
 	}
 
 	bg.wg.Add(1)
-	return true
+	return nil
 }
 
-func (bg *BackgroundLimiter) done() {
-	if bg.ch != nil {
-		<-bg.ch
+// wait waits for notifyWaiters to give w a slot, or for either context to be done.
+func (bg *BackgroundLimiter) wait(wctx context.Context, w *waiter) error {
+	var err error
+	select {
+	case <-w.ready:
+		return w.err
+	case <-wctx.Done():
+		err = wctx.Err()
+	case <-bg.ctx.Done():
+		err = context.Canceled
 	}
 
+	bg.mux.Lock()
+	select {
+	case <-w.ready:
+		// got a slot right as we gave up, pass it on.
+		if w.err == nil {
+			bg.running--
+			bg.notifyWaiters()
+		}
+	default:
+		bg.queue.remove(w)
+	}
+	bg.mux.Unlock()
+
+	return err
+}
+
+func (bg *BackgroundLimiter) done(latency time.Duration, err error) {
+	bg.mux.Lock()
+	if bg.alg != nil && bg.ctx.Err() == nil {
+		bg.limit = bg.alg.Update(bg.limit, Sample{Latency: latency, InFlight: bg.running, Failed: err != nil})
+	}
+	bg.running--
+	bg.notifyWaiters()
+	bg.mux.Unlock()
+
 	bg.wg.Done()
 }
+
+func (bg *BackgroundLimiter) release() {
+	bg.mux.Lock()
+	bg.running--
+	bg.notifyWaiters()
+	bg.mux.Unlock()
+}
+
+// hasSlot must be called with bg.mux held.
+func (bg *BackgroundLimiter) hasSlot() bool {
+	return bg.limit <= 0 || bg.running < bg.limit
+}
+
+// notifyWaiters must be called with bg.mux held.
+func (bg *BackgroundLimiter) notifyWaiters() {
+	for bg.queue.Len() > 0 && bg.hasSlot() {
+		bg.running++
+		close(bg.queue.pop().ready)
+	}
+}
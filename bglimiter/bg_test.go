@@ -2,10 +2,14 @@ package bglimiter_test
 
 import (
 	"context"
+	"errors"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/PathDNA/ptk"
 	"github.com/PathDNA/ptk/bglimiter"
 )
 
@@ -64,3 +68,352 @@ func TestLimit(t *testing.T) {
 		t.Fatal("bg.IsCanceled() :( :(")
 	}
 }
+
+func TestSetLimit(t *testing.T) {
+	var (
+		bg      = bglimiter.NewWithContext(context.Background(), 1)
+		release = make(chan struct{})
+	)
+	defer bg.Close()
+
+	fn := func(ctx context.Context) error {
+		<-release
+		return nil
+	}
+
+	bg.Add(fn)
+	go bg.Add(fn)
+	go bg.Add(fn)
+
+	time.Sleep(time.Millisecond * 2)
+	if r, w := bg.Running(), bg.Waiting(); r != 1 || w != 2 {
+		t.Fatalf("expected 1 running and 2 waiting, got %d and %d", r, w)
+	}
+
+	bg.SetLimit(3)
+	time.Sleep(time.Millisecond * 2)
+	if r, w := bg.Running(), bg.Waiting(); r != 3 || w != 0 {
+		t.Fatalf("expected 3 running and 0 waiting, got %d and %d", r, w)
+	}
+
+	bg.SetLimit(1)
+	go bg.Add(fn)
+	time.Sleep(time.Millisecond * 2)
+	if r, w := bg.Running(), bg.Waiting(); r != 3 || w != 1 {
+		t.Fatalf("expected 3 running and 1 waiting, got %d and %d", r, w)
+	}
+
+	close(release)
+	time.Sleep(time.Millisecond * 2)
+	bg.Wait()
+	if r, w := bg.Running(), bg.Waiting(); r != 0 || w != 0 {
+		t.Fatalf("expected 0 running and 0 waiting, got %d and %d", r, w)
+	}
+}
+
+func TestPriorityAndTenants(t *testing.T) {
+	var (
+		bg = bglimiter.NewWithOptions(context.Background(), bglimiter.Options{
+			Limit:         1,
+			MaxQueue:      6,
+			TenantWeights: map[string]int{"a": 2},
+		})
+		release = make(chan struct{})
+		order   = make(chan string, 6)
+	)
+	defer bg.Close()
+
+	bg.Add(func(ctx context.Context) error {
+		<-release
+		return nil
+	})
+
+	add := func(name string, pri bglimiter.Priority, tenant string) {
+		go bg.AddWithOptions(bglimiter.TaskOptions{Priority: pri, Tenant: tenant}, func(ctx context.Context) error {
+			order <- name
+			return nil
+		})
+		time.Sleep(time.Millisecond) // keep the submission order stable
+	}
+
+	add("a1", bglimiter.PriorityNormal, "a")
+	add("a2", bglimiter.PriorityNormal, "a")
+	add("a3", bglimiter.PriorityNormal, "a")
+	add("b1", bglimiter.PriorityNormal, "b")
+	add("b2", bglimiter.PriorityNormal, "b")
+	add("high", bglimiter.PriorityHigh, "b")
+
+	if err := <-bg.Add(func(ctx context.Context) error { return nil }); err != bglimiter.ErrQueueFull {
+		t.Fatalf("expected ErrQueueFull, got %v", err)
+	}
+
+	close(release)
+
+	var got []string
+	for i := 0; i < 6; i++ {
+		got = append(got, <-order)
+	}
+
+	if exp := "high a1 a2 b1 a3 b2"; strings.Join(got, " ") != exp {
+		t.Fatalf("expected %q, got %q", exp, strings.Join(got, " "))
+	}
+}
+
+func TestNonBlockingAdd(t *testing.T) {
+	var (
+		bg      = bglimiter.NewWithContext(context.Background(), 1)
+		release = make(chan struct{})
+	)
+
+	bg.Add(func(ctx context.Context) error {
+		<-release
+		return nil
+	})
+
+	if err := <-bg.TryAdd(func(ctx context.Context) error { return nil }); err != bglimiter.ErrLimitReached {
+		t.Fatalf("expected ErrLimitReached, got %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	if err := <-bg.AddCtx(ctx, func(ctx context.Context) error { return nil }); err != context.DeadlineExceeded {
+		t.Fatalf("expected DeadlineExceeded, got %v", err)
+	}
+
+	if w := bg.Waiting(); w != 0 {
+		t.Fatalf("expected 0 waiting, got %d", w)
+	}
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- <-bg.Add(func(ctx context.Context) error { return nil }) }()
+	time.Sleep(time.Millisecond)
+
+	// the task holding the slot never returns, Close must still wake up the blocked Add
+	bg.Close()
+	select {
+	case err := <-errCh:
+		if err != context.Canceled {
+			t.Fatalf("expected Canceled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("blocked Add didn't return after Close")
+	}
+}
+
+func TestPanicAndResult(t *testing.T) {
+	var (
+		failed = make(chan error, 1)
+		bg     = bglimiter.NewWithOptions(context.Background(), bglimiter.Options{
+			Limit:   1,
+			OnError: func(err error) { failed <- err },
+		})
+	)
+	defer bg.Close()
+
+	err := <-bg.Add(func(ctx context.Context) error { panic("boom") })
+	if pe, ok := err.(*ptk.PanicError); !ok || pe.Value != "boom" || len(pe.Stack) == 0 {
+		t.Fatalf("expected a *PanicError, got %#v", err)
+	}
+
+	if herr := <-failed; herr != err {
+		t.Fatalf("expected OnError to get %v, got %v", err, herr)
+	}
+
+	f := bglimiter.AddResult(bg, func(ctx context.Context) (int, error) { return 42, nil })
+	if v, err := f.Get(); v != 42 || err != nil {
+		t.Fatalf("expected 42, <nil>, got %v, %v", v, err)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	var (
+		bg      = bglimiter.NewWithContext(context.Background(), 2)
+		release = make(chan struct{})
+	)
+
+	bg.Add(func(ctx context.Context) error {
+		<-release
+		return nil
+	})
+
+	bg.Add(func(ctx context.Context) error {
+		time.Sleep(100 * time.Millisecond) // ignores ctx
+		return nil
+	})
+
+	queued := make(chan error, 1)
+	go func() { queued <- <-bg.Add(func(ctx context.Context) error { return nil }) }()
+	for bg.Waiting() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	go func() {
+		for bg.Waiting() > 0 {
+			time.Sleep(time.Millisecond)
+		}
+		close(release)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	r, err := bg.Shutdown(ctx)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expected DeadlineExceeded, got %v", err)
+	}
+
+	if exp := (bglimiter.ShutdownReport{Finished: 1, Cancelled: 1, Running: 1}); r != exp {
+		t.Fatalf("expected %+v, got %+v", exp, r)
+	}
+
+	if err := <-queued; err != bglimiter.ErrShutdown {
+		t.Fatalf("expected ErrShutdown, got %v", err)
+	}
+
+	if err := <-bg.Add(func(ctx context.Context) error { return nil }); err != context.Canceled {
+		t.Fatalf("expected Canceled, got %v", err)
+	}
+}
+
+func TestStatsAndTasks(t *testing.T) {
+	var (
+		bg      = bglimiter.NewWithOptions(context.Background(), bglimiter.Options{Limit: 1, MaxQueue: 1})
+		release = make(chan struct{})
+	)
+	defer bg.Close()
+
+	first := bg.AddWithOptions(bglimiter.TaskOptions{Name: "sweep", Labels: map[string]string{"db": "main"}}, func(ctx context.Context) error {
+		<-release
+		return nil
+	})
+
+	second := make(chan (<-chan error), 1)
+	go func() {
+		second <- bg.AddWithOptions(bglimiter.TaskOptions{Timeout: time.Millisecond}, func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		})
+	}()
+	for bg.Waiting() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	if tasks := bg.Tasks(); len(tasks) != 1 || tasks[0].Name != "sweep" || tasks[0].Labels["db"] != "main" || tasks[0].Started.IsZero() {
+		t.Fatalf("unexpected tasks: %+v", tasks)
+	}
+
+	if err := <-bg.Add(func(ctx context.Context) error { return nil }); err != bglimiter.ErrQueueFull {
+		t.Fatalf("expected ErrQueueFull, got %v", err)
+	}
+
+	close(release)
+	<-first
+	<-<-second
+	<-bg.Add(func(ctx context.Context) error { return errors.New("nope") })
+
+	s := bg.Stats()
+	if s.Submitted != 4 || s.Completed != 1 || s.TimedOut != 1 || s.Rejected != 1 || s.Failed != 1 {
+		t.Fatalf("unexpected stats: %+v", s)
+	}
+
+	if s.QueueWait.Count != 3 || s.QueueWait.Counts[len(s.QueueWait.Counts)-1] != 0 {
+		t.Fatalf("unexpected queue wait histogram: %+v", s.QueueWait)
+	}
+}
+
+func TestPeriodic(t *testing.T) {
+	bg := bglimiter.NewWithContext(context.Background(), 2)
+	defer bg.Close()
+
+	var runs, cur, max int64
+	stop := bg.AddPeriodic(bglimiter.PeriodicOptions{Interval: 5 * time.Millisecond, Jitter: time.Millisecond}, func(ctx context.Context) error {
+		n := atomic.AddInt64(&cur, 1)
+		defer atomic.AddInt64(&cur, -1)
+		if n > atomic.LoadInt64(&max) {
+			atomic.StoreInt64(&max, n)
+		}
+		atomic.AddInt64(&runs, 1)
+		time.Sleep(12 * time.Millisecond) // longer than the interval
+		return nil
+	})
+
+	time.Sleep(100 * time.Millisecond)
+	stop()
+	time.Sleep(20 * time.Millisecond)
+
+	n := atomic.LoadInt64(&runs)
+	if n < 2 || n > 10 {
+		t.Fatalf("unexpected number of runs: %d", n)
+	}
+
+	if atomic.LoadInt64(&max) != 1 {
+		t.Fatal("overlapping runs")
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if atomic.LoadInt64(&runs) != n {
+		t.Fatal("ran after stop")
+	}
+
+	var every int64
+	bg.Every(time.Millisecond, func(ctx context.Context) error {
+		atomic.AddInt64(&every, 1)
+		return nil
+	})
+	time.Sleep(20 * time.Millisecond)
+	bg.Close()
+	time.Sleep(5 * time.Millisecond)
+	n = atomic.LoadInt64(&every)
+	time.Sleep(10 * time.Millisecond)
+	if n == 0 || atomic.LoadInt64(&every) != n {
+		t.Fatalf("Every didn't stop on Close: %d", n)
+	}
+}
+
+func TestKeyed(t *testing.T) {
+	bg := bglimiter.NewWithContext(context.Background(), 4)
+	defer bg.Close()
+
+	var (
+		mux   sync.Mutex
+		order = map[string][]int{}
+		cur   = map[string]int{}
+	)
+
+	for i := 0; i < 50; i++ {
+		i, key := i, string(rune('a'+i%3))
+		bg.AddKeyed(key, func(ctx context.Context) error {
+			mux.Lock()
+			if cur[key]++; cur[key] > 1 {
+				t.Errorf("key %s ran concurrently", key)
+			}
+			order[key] = append(order[key], i)
+			mux.Unlock()
+
+			time.Sleep(time.Millisecond)
+
+			mux.Lock()
+			cur[key]--
+			mux.Unlock()
+			return nil
+		})
+	}
+
+	bg.Wait()
+
+	for key, ids := range order {
+		for i := 1; i < len(ids); i++ {
+			if ids[i] < ids[i-1] {
+				t.Fatalf("key %s ran out of order: %v", key, ids)
+			}
+		}
+	}
+
+	if len(order["a"])+len(order["b"])+len(order["c"]) != 50 {
+		t.Fatalf("not all tasks ran: %v", order)
+	}
+
+	if err := <-bg.AddKeyed("a", func(ctx context.Context) error { return errors.New("x") }); err == nil || err.Error() != "x" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
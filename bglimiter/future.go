@@ -0,0 +1,52 @@
+package bglimiter
+
+import "context"
+
+// AddResult is like bg.Add, but for funcs that return a value.
+func AddResult[T any](bg *BackgroundLimiter, fn func(ctx context.Context) (T, error)) *Future[T] {
+	var (
+		f   = &Future[T]{done: make(chan struct{})}
+		val T
+	)
+
+	errCh := bg.Add(func(ctx context.Context) (err error) {
+		val, err = fn(ctx)
+		return
+	})
+
+	go func() {
+		// a nil error means fn returned, otherwise it might still be running and we can't touch val.
+		if f.err = <-errCh; f.err == nil {
+			f.val = val
+		}
+		close(f.done)
+	}()
+
+	return f
+}
+
+// Future holds the result of a task submitted with AddResult.
+type Future[T any] struct {
+	done chan struct{}
+	val  T
+	err  error
+}
+
+// Done returns a channel that gets closed once the result is ready.
+func (f *Future[T]) Done() <-chan struct{} { return f.done }
+
+// Get blocks until the result is ready, the value is always the zero value if err != nil.
+func (f *Future[T]) Get() (T, error) {
+	<-f.done
+	return f.val, f.err
+}
+
+// GetCtx is like Get, but returns ctx.Err() if ctx is done before the result is ready.
+func (f *Future[T]) GetCtx(ctx context.Context) (v T, err error) {
+	select {
+	case <-f.done:
+		return f.val, f.err
+	case <-ctx.Done():
+		return v, ctx.Err()
+	}
+}
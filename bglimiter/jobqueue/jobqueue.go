@@ -0,0 +1,536 @@
+// Package jobqueue is a durable job queue on top of bglimiter.
+// Jobs are appended to a local write-ahead log before they run, and executed with at-least-once semantics:
+// jobs that were running when the process died run again on the next Open.
+package jobqueue
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/PathDNA/ptk/bglimiter"
+)
+
+// ErrNotFound is returned by Requeue if the job isn't in the dead-letter list.
+var ErrNotFound = errors.New("jobqueue: job not found")
+
+// Handler runs a job, returning an error schedules a retry.
+type Handler func(ctx context.Context, payload json.RawMessage) error
+
+// Job is a queued job.
+type Job struct {
+	ID        uint64          `json:"id"`
+	Name      string          `json:"name,omitempty"`
+	Payload   json.RawMessage `json:"payload,omitempty"`
+	Attempts  int             `json:"attempts,omitempty"`
+	LastError string          `json:"error,omitempty"`
+	Enqueued  time.Time       `json:"enqueued"`
+	NextRun   time.Time       `json:"next"`
+}
+
+// Options are passed to Open.
+type Options struct {
+	// MaxAttempts is how many times a job runs before it's moved to the dead-letter list, defaults to 5.
+	MaxAttempts int
+	// Backoff is the delay before the first retry, it doubles on every attempt, defaults to 1 second.
+	Backoff time.Duration
+	// MaxBackoff defaults to 1 hour.
+	MaxBackoff time.Duration
+	// Sync calls fsync after every write.
+	Sync bool
+}
+
+// Open opens or creates the log at path, replays it and compacts it.
+// Jobs don't start running until Start is called, so handlers can be registered first.
+// If bg is nil, bglimiter.New() is used.
+func Open(path string, bg *bglimiter.BackgroundLimiter, opts Options) (*Queue, error) {
+	if bg == nil {
+		bg = bglimiter.New()
+	}
+
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 5
+	}
+
+	if opts.Backoff <= 0 {
+		opts.Backoff = time.Second
+	}
+
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = time.Hour
+	}
+
+	q := &Queue{
+		path:     path,
+		bg:       bg,
+		opts:     opts,
+		handlers: map[string]Handler{},
+		jobs:     map[uint64]*Job{},
+		dead:     map[uint64]*Job{},
+		inflight: map[uint64]bool{},
+		wake:     make(chan struct{}, 1),
+	}
+	q.ctx, q.cancel = context.WithCancel(bg.Context())
+
+	if err := q.replay(); err != nil {
+		return nil, err
+	}
+
+	if err := q.compact(); err != nil {
+		return nil, err
+	}
+
+	return q, nil
+}
+
+// Queue is a durable job queue.
+type Queue struct {
+	path string
+	bg   *bglimiter.BackgroundLimiter
+	opts Options
+
+	mux      sync.Mutex
+	f        *os.File
+	handlers map[string]Handler
+	jobs     map[uint64]*Job
+	dead     map[uint64]*Job
+	inflight map[uint64]bool
+	lastID   uint64
+	started  bool
+
+	ctx    context.Context
+	cancel func()
+	wake   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// Register sets the handler for jobs with the given name.
+func (q *Queue) Register(name string, h Handler) {
+	q.mux.Lock()
+	q.handlers[name] = h
+	q.mux.Unlock()
+	q.notify()
+}
+
+// Start starts running jobs, it's a no-op if it was already called.
+func (q *Queue) Start() {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	if q.started {
+		return
+	}
+	q.started = true
+
+	q.wg.Add(1)
+	go q.loop()
+}
+
+// Enqueue json encodes payload and adds a job with the given name to the queue.
+// payload can be a json.RawMessage to pass it as-is.
+func (q *Queue) Enqueue(name string, payload interface{}) (id uint64, err error) {
+	var raw json.RawMessage
+	if raw, err = json.Marshal(payload); err != nil {
+		return
+	}
+
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	now := time.Now()
+	q.lastID++
+	j := &Job{ID: q.lastID, Name: name, Payload: raw, Enqueued: now, NextRun: now}
+	if err = q.write(opEnqueue, j); err != nil {
+		q.lastID--
+		return
+	}
+	q.jobs[j.ID] = j
+	q.notify()
+
+	return j.ID, nil
+}
+
+// Pending returns the number of jobs waiting to run or running.
+func (q *Queue) Pending() int {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	return len(q.jobs)
+}
+
+// DeadLetters returns the jobs that failed MaxAttempts times, sorted by id.
+func (q *Queue) DeadLetters() []Job {
+	q.mux.Lock()
+	out := make([]Job, 0, len(q.dead))
+	for _, j := range q.dead {
+		out = append(out, *j)
+	}
+	q.mux.Unlock()
+
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// Requeue moves a dead job back to the queue with its attempts reset.
+func (q *Queue) Requeue(id uint64) error {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	j := q.dead[id]
+	if j == nil {
+		return ErrNotFound
+	}
+
+	nj := *j
+	nj.Attempts, nj.NextRun = 0, time.Now()
+	if err := q.write(opEnqueue, &nj); err != nil {
+		return err
+	}
+
+	delete(q.dead, id)
+	q.jobs[id] = &nj
+	q.notify()
+	return nil
+}
+
+// Compact rewrites the log with only the live jobs.
+func (q *Queue) Compact() error {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	if q.f == nil {
+		return os.ErrClosed
+	}
+	return q.compact()
+}
+
+// Close stops running new jobs, waits for the running ones to return and closes the log.
+// Jobs interrupted by closing the limiter run again on the next Open.
+func (q *Queue) Close() error {
+	q.cancel()
+	q.wg.Wait()
+
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	if q.f == nil {
+		return os.ErrClosed
+	}
+
+	err := q.f.Close()
+	q.f = nil
+	return err
+}
+
+func (q *Queue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (q *Queue) loop() {
+	defer q.wg.Done()
+
+	for {
+		j, h, wait := q.next()
+		if j != nil {
+			q.run(j, h)
+			continue
+		}
+
+		t := time.NewTimer(wait)
+		select {
+		case <-q.ctx.Done():
+			t.Stop()
+			return
+		case <-q.wake:
+			t.Stop()
+		case <-t.C:
+		}
+	}
+}
+
+// next returns the next due job, or how long to wait for one.
+func (q *Queue) next() (due *Job, h Handler, wait time.Duration) {
+	now := time.Now()
+	wait = time.Hour
+
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	for _, j := range q.jobs {
+		if q.inflight[j.ID] || q.handlers[j.Name] == nil {
+			continue
+		}
+
+		if d := j.NextRun.Sub(now); d > 0 {
+			if d < wait {
+				wait = d
+			}
+			continue
+		}
+
+		if due == nil || j.NextRun.Before(due.NextRun) || (j.NextRun.Equal(due.NextRun) && j.ID < due.ID) {
+			due = j
+		}
+	}
+
+	if due != nil {
+		h = q.handlers[due.Name]
+	}
+
+	return
+}
+
+func (q *Queue) run(j *Job, h Handler) {
+	q.mux.Lock()
+	j.Attempts++
+	err := q.write(opStart, &Job{ID: j.ID, Attempts: j.Attempts})
+	if err == nil {
+		q.inflight[j.ID] = true
+	}
+	payload := j.Payload
+	q.mux.Unlock()
+
+	if err != nil {
+		// couldn't write the log, there's no point in running anything else
+		q.cancel()
+		return
+	}
+
+	// blocks the loop until the limiter has a free slot.
+	errCh := q.bg.AddCtx(q.ctx, func(ctx context.Context) error {
+		return h(ctx, payload)
+	})
+
+	q.wg.Add(1)
+	go func() {
+		defer q.wg.Done()
+		q.finish(j, <-errCh)
+	}()
+}
+
+func (q *Queue) finish(j *Job, err error) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	delete(q.inflight, j.ID)
+
+	if err != nil && (q.bg.IsCanceled() || err == q.ctx.Err()) {
+		// interrupted, the start record is enough to run it again on the next Open.
+		return
+	}
+
+	switch {
+	case err == nil:
+		if q.write(opAck, &Job{ID: j.ID}) == nil {
+			delete(q.jobs, j.ID)
+		}
+
+	case j.Attempts >= q.opts.MaxAttempts:
+		j.LastError = err.Error()
+		if q.write(opDead, &Job{ID: j.ID, LastError: j.LastError}) == nil {
+			delete(q.jobs, j.ID)
+			q.dead[j.ID] = j
+		}
+
+	default:
+		j.LastError = err.Error()
+		j.NextRun = time.Now().Add(q.backoff(j.Attempts))
+		q.write(opFail, &Job{ID: j.ID, LastError: j.LastError, NextRun: j.NextRun})
+	}
+
+	q.notify()
+}
+
+func (q *Queue) backoff(attempts int) time.Duration {
+	d := q.opts.Backoff
+	for i := 1; i < attempts && d < q.opts.MaxBackoff; i++ {
+		d *= 2
+	}
+
+	if d > q.opts.MaxBackoff {
+		d = q.opts.MaxBackoff
+	}
+
+	return d
+}
+
+const (
+	opEnqueue = "enqueue"
+	opStart   = "start"
+	opAck     = "ack"
+	opFail    = "fail"
+	opDead    = "dead"
+)
+
+type record struct {
+	Op string `json:"op"`
+	*Job
+}
+
+// write must be called with q.mux held.
+func (q *Queue) write(op string, j *Job) error {
+	if q.f == nil {
+		return os.ErrClosed
+	}
+
+	b, err := json.Marshal(record{op, j})
+	if err != nil {
+		return err
+	}
+
+	if _, err = q.f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+
+	if q.opts.Sync {
+		return q.f.Sync()
+	}
+
+	return nil
+}
+
+func (q *Queue) replay() error {
+	f, err := os.Open(q.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var (
+		sc   = bufio.NewScanner(f)
+		line int
+	)
+	sc.Buffer(nil, 64<<20)
+
+	for sc.Scan() {
+		line++
+
+		var r record
+		if err := json.Unmarshal(sc.Bytes(), &r); err != nil || r.Job == nil {
+			// a torn write at the end of the log is expected after a crash.
+			if !sc.Scan() {
+				break
+			}
+			return fmt.Errorf("jobqueue: %s:%d: corrupted record", q.path, line)
+		}
+
+		if r.ID > q.lastID {
+			q.lastID = r.ID
+		}
+
+		q.apply(&r)
+	}
+
+	if err := sc.Err(); err != nil {
+		return err
+	}
+
+	// jobs that were running when we died already used up an attempt.
+	for id, j := range q.jobs {
+		if j.Attempts >= q.opts.MaxAttempts {
+			j.LastError = "jobqueue: interrupted"
+			delete(q.jobs, id)
+			q.dead[id] = j
+		}
+	}
+
+	return nil
+}
+
+func (q *Queue) apply(r *record) {
+	switch r.Op {
+	case opEnqueue:
+		j := *r.Job
+		delete(q.dead, j.ID)
+		q.jobs[j.ID] = &j
+
+	case opStart:
+		if j := q.jobs[r.ID]; j != nil {
+			j.Attempts = r.Attempts
+		}
+
+	case opAck:
+		delete(q.jobs, r.ID)
+
+	case opFail:
+		if j := q.jobs[r.ID]; j != nil {
+			j.LastError, j.NextRun = r.LastError, r.NextRun
+		}
+
+	case opDead:
+		if j := q.jobs[r.ID]; j != nil {
+			j.LastError = r.LastError
+			delete(q.jobs, r.ID)
+			q.dead[r.ID] = j
+		}
+	}
+}
+
+// compact must be called with q.mux held or before the queue is shared.
+func (q *Queue) compact() (err error) {
+	tmp := q.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+
+	var (
+		bw  = bufio.NewWriter(f)
+		enc = json.NewEncoder(bw)
+		ids = make([]uint64, 0, len(q.jobs)+len(q.dead))
+	)
+
+	for id := range q.jobs {
+		ids = append(ids, id)
+	}
+	for id := range q.dead {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	for _, id := range ids {
+		if j := q.jobs[id]; j != nil {
+			err = enc.Encode(record{opEnqueue, j})
+		} else {
+			j = q.dead[id]
+			if err = enc.Encode(record{opEnqueue, j}); err == nil {
+				err = enc.Encode(record{opDead, &Job{ID: id, LastError: j.LastError}})
+			}
+		}
+
+		if err != nil {
+			break
+		}
+	}
+
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, q.path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if q.f != nil {
+		q.f.Close()
+	}
+
+	q.f, err = os.OpenFile(q.path, os.O_APPEND|os.O_WRONLY, 0o644)
+	return err
+}
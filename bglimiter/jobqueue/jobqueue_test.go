@@ -0,0 +1,145 @@
+package jobqueue_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/PathDNA/ptk/bglimiter"
+	"github.com/PathDNA/ptk/bglimiter/jobqueue"
+)
+
+func waitFor(t *testing.T, fn func() bool) {
+	t.Helper()
+	for i := 0; i < 500; i++ {
+		if fn() {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("timed out")
+}
+
+func TestQueue(t *testing.T) {
+	var (
+		path = filepath.Join(t.TempDir(), "jobs.log")
+		opts = jobqueue.Options{MaxAttempts: 3, Backoff: time.Millisecond}
+	)
+
+	q, err := jobqueue.Open(path, bglimiter.NewWithContext(context.Background(), 2), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sum, fails int64
+	q.Register("add", func(ctx context.Context, payload json.RawMessage) error {
+		var n int64
+		if err := json.Unmarshal(payload, &n); err != nil {
+			return err
+		}
+		atomic.AddInt64(&sum, n)
+		return nil
+	})
+	q.Register("fail", func(ctx context.Context, payload json.RawMessage) error {
+		atomic.AddInt64(&fails, 1)
+		return errors.New("nope")
+	})
+
+	for i := 1; i <= 10; i++ {
+		if _, err := q.Enqueue("add", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	failID, _ := q.Enqueue("fail", nil)
+	q.Enqueue("later", "x") // no handler yet
+
+	q.Start()
+	waitFor(t, func() bool { return q.Pending() == 1 && len(q.DeadLetters()) == 1 })
+
+	if sum := atomic.LoadInt64(&sum); sum != 55 {
+		t.Fatalf("expected 55, got %d", sum)
+	}
+
+	if fails := atomic.LoadInt64(&fails); fails != 3 {
+		t.Fatalf("expected 3 attempts, got %d", fails)
+	}
+
+	dl := q.DeadLetters()[0]
+	if dl.ID != failID || dl.Attempts != 3 || dl.LastError != "nope" {
+		t.Fatalf("unexpected dead letter: %+v", dl)
+	}
+
+	if err := q.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// reopen, the pending and dead jobs should survive and the log should be compacted.
+	q, err = jobqueue.Open(path, nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	if q.Pending() != 1 || len(q.DeadLetters()) != 1 {
+		t.Fatalf("unexpected state after reopen: %d pending, %d dead", q.Pending(), len(q.DeadLetters()))
+	}
+
+	if err := q.Requeue(failID); err != nil {
+		t.Fatal(err)
+	}
+
+	ran := make(chan string, 2)
+	q.Register("later", func(ctx context.Context, payload json.RawMessage) error {
+		ran <- string(payload)
+		return nil
+	})
+	q.Register("fail", func(ctx context.Context, payload json.RawMessage) error {
+		ran <- "fail"
+		return nil
+	})
+	q.Start()
+
+	waitFor(t, func() bool { return q.Pending() == 0 })
+	if len(ran) != 2 || len(q.DeadLetters()) != 0 {
+		t.Fatalf("unexpected state: %d ran, %d dead", len(ran), len(q.DeadLetters()))
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.log")
+
+	// a job that started but never finished, followed by a torn write.
+	log := `{"op":"enqueue","id":1,"name":"job","payload":1,"enqueued":"2020-01-01T00:00:00Z","next":"2020-01-01T00:00:00Z"}
+{"op":"start","id":1,"attempts":1,"enqueued":"0001-01-01T00:00:00Z","next":"0001-01-01T00:00:00Z"}
+{"op":"enqueue","id":2,"na`
+	if err := os.WriteFile(path, []byte(log), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	q, err := jobqueue.Open(path, nil, jobqueue.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	var attempts int64
+	q.Register("job", func(ctx context.Context, payload json.RawMessage) error {
+		atomic.AddInt64(&attempts, 1)
+		return nil
+	})
+	q.Start()
+
+	waitFor(t, func() bool { return q.Pending() == 0 })
+	if attempts := atomic.LoadInt64(&attempts); attempts != 1 {
+		t.Fatalf("expected the interrupted job to run again, got %d", attempts)
+	}
+
+	if id, _ := q.Enqueue("job", 2); id != 2 {
+		t.Fatalf("expected id 2, got %d", id)
+	}
+}
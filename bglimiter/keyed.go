@@ -0,0 +1,59 @@
+package bglimiter
+
+import "context"
+
+type keyedTask struct {
+	opts    TaskOptions
+	fn      func(ctx context.Context) error
+	errChan chan error
+}
+
+// AddKeyed is an alias for AddKeyedWithOptions(key, TaskOptions{}, fn).
+func (bg *BackgroundLimiter) AddKeyed(key string, fn func(ctx context.Context) error) <-chan error {
+	return bg.AddKeyedWithOptions(key, TaskOptions{}, fn)
+}
+
+// AddKeyedWithOptions runs fn after all the tasks previously added with the same key returned,
+// tasks with different keys run in parallel within the limit.
+// Unlike Add, it never blocks, tasks that can't run yet are queued and Wait waits for them too.
+func (bg *BackgroundLimiter) AddKeyedWithOptions(key string, opts TaskOptions, fn func(ctx context.Context) error) <-chan error {
+	t := &keyedTask{opts: opts, fn: fn, errChan: make(chan error, 1)}
+
+	bg.wg.Add(1)
+
+	bg.kmux.Lock()
+	if bg.keys == nil {
+		bg.keys = map[string][]*keyedTask{}
+	}
+	q, busy := bg.keys[key]
+	bg.keys[key] = append(q, t)
+	bg.kmux.Unlock()
+
+	// only one goroutine per busy key, it exits once the key's queue is empty.
+	if !busy {
+		go bg.runKeyed(key)
+	}
+
+	return t.errChan
+}
+
+func (bg *BackgroundLimiter) runKeyed(key string) {
+	for {
+		bg.kmux.Lock()
+		q := bg.keys[key]
+		if len(q) == 0 {
+			delete(bg.keys, key)
+			bg.kmux.Unlock()
+			return
+		}
+		t := q[0]
+		q[0] = nil
+		bg.keys[key] = q[1:]
+		bg.kmux.Unlock()
+
+		err := <-bg.AddWithOptions(t.opts, t.fn)
+		t.errChan <- err
+		close(t.errChan)
+		bg.wg.Done()
+	}
+}
@@ -0,0 +1,228 @@
+package bglimiter
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultQueueWaitBuckets are the upper bounds of the queue wait histogram unless Options.QueueWaitBuckets is set.
+var DefaultQueueWaitBuckets = []time.Duration{
+	time.Millisecond, 5 * time.Millisecond, 10 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 500 * time.Millisecond, time.Second, 5 * time.Second, 10 * time.Second,
+}
+
+// Status is how a task ended.
+type Status int
+
+const (
+	StatusCompleted Status = iota
+	StatusFailed
+	StatusTimedOut
+	StatusCancelled
+	StatusRejected
+)
+
+func (s Status) String() string {
+	switch s {
+	case StatusCompleted:
+		return "completed"
+	case StatusFailed:
+		return "failed"
+	case StatusTimedOut:
+		return "timed out"
+	case StatusCancelled:
+		return "cancelled"
+	case StatusRejected:
+		return "rejected"
+	default:
+		return "unknown"
+	}
+}
+
+func statusOf(err error) Status {
+	switch err {
+	case nil:
+		return StatusCompleted
+	case context.DeadlineExceeded:
+		return StatusTimedOut
+	case context.Canceled, ErrShutdown:
+		return StatusCancelled
+	case ErrQueueFull, ErrLimitReached:
+		return StatusRejected
+	default:
+		return StatusFailed
+	}
+}
+
+// TaskInfo describes a submitted task.
+type TaskInfo struct {
+	ID       uint64
+	Name     string
+	Labels   map[string]string
+	Priority Priority
+	Tenant   string
+
+	Submitted time.Time
+	// Started is the zero time if the task never got a slot.
+	Started time.Time
+}
+
+// Metrics can be passed in Options to export the limiter's metrics.
+// The methods are called synchronously from the task's goroutines, so they should be fast.
+type Metrics interface {
+	TaskSubmitted(t TaskInfo)
+	TaskStarted(t TaskInfo, queueWait time.Duration)
+	TaskFinished(t TaskInfo, runTime time.Duration, status Status, err error)
+}
+
+// Stats is a snapshot of the limiter's counters.
+type Stats struct {
+	Submitted uint64
+	Completed uint64
+	Failed    uint64
+	TimedOut  uint64
+	Cancelled uint64
+	Rejected  uint64
+
+	QueueWait Histogram
+}
+
+// Histogram is a simple cumulative histogram.
+type Histogram struct {
+	// Bounds are the upper bounds of each bucket, sorted.
+	Bounds []time.Duration
+	// Counts has one more entry than Bounds for values over the last bound.
+	Counts []uint64
+	Count  uint64
+	Sum    time.Duration
+}
+
+// NewHistogram returns a Histogram with the given bucket upper bounds.
+func NewHistogram(bounds ...time.Duration) Histogram {
+	bounds = append([]time.Duration(nil), bounds...)
+	sort.Slice(bounds, func(i, j int) bool { return bounds[i] < bounds[j] })
+	return Histogram{Bounds: bounds, Counts: make([]uint64, len(bounds)+1)}
+}
+
+func (h *Histogram) Observe(d time.Duration) {
+	h.Counts[sort.Search(len(h.Bounds), func(i int) bool { return d <= h.Bounds[i] })]++
+	h.Count++
+	h.Sum += d
+}
+
+func (h Histogram) clone() Histogram {
+	h.Bounds = append([]time.Duration(nil), h.Bounds...)
+	h.Counts = append([]uint64(nil), h.Counts...)
+	return h
+}
+
+type tracker struct {
+	mux     sync.Mutex
+	stats   Stats
+	running map[uint64]*TaskInfo
+	nextID  uint64
+
+	m Metrics
+}
+
+func (t *tracker) submitted(opts TaskOptions) *TaskInfo {
+	ti := &TaskInfo{
+		Name:      opts.Name,
+		Labels:    opts.Labels,
+		Priority:  opts.Priority,
+		Tenant:    opts.Tenant,
+		Submitted: time.Now(),
+	}
+
+	t.mux.Lock()
+	t.nextID++
+	ti.ID = t.nextID
+	t.stats.Submitted++
+	t.mux.Unlock()
+
+	if t.m != nil {
+		t.m.TaskSubmitted(*ti)
+	}
+
+	return ti
+}
+
+func (t *tracker) started(ti *TaskInfo) {
+	ti.Started = time.Now()
+	wait := ti.Started.Sub(ti.Submitted)
+
+	t.mux.Lock()
+	t.stats.QueueWait.Observe(wait)
+	t.running[ti.ID] = ti
+	t.mux.Unlock()
+
+	if t.m != nil {
+		t.m.TaskStarted(*ti, wait)
+	}
+}
+
+// returned is called when the task's fn returns, which can be after finished if it ignores its ctx.
+func (t *tracker) returned(ti *TaskInfo) {
+	t.mux.Lock()
+	delete(t.running, ti.ID)
+	t.mux.Unlock()
+}
+
+func (t *tracker) finished(ti *TaskInfo, err error) {
+	var (
+		status  = statusOf(err)
+		runTime time.Duration
+	)
+
+	if !ti.Started.IsZero() {
+		runTime = time.Since(ti.Started)
+	}
+
+	t.mux.Lock()
+	switch status {
+	case StatusCompleted:
+		t.stats.Completed++
+	case StatusFailed:
+		t.stats.Failed++
+	case StatusTimedOut:
+		t.stats.TimedOut++
+	case StatusCancelled:
+		t.stats.Cancelled++
+	case StatusRejected:
+		t.stats.Rejected++
+	}
+	t.mux.Unlock()
+
+	if t.m != nil {
+		t.m.TaskFinished(*ti, runTime, status, err)
+	}
+}
+
+func (t *tracker) tasks() []TaskInfo {
+	t.mux.Lock()
+	out := make([]TaskInfo, 0, len(t.running))
+	for _, ti := range t.running {
+		out = append(out, *ti)
+	}
+	t.mux.Unlock()
+
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+func (t *tracker) snapshot() Stats {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	s := t.stats
+	s.QueueWait = s.QueueWait.clone()
+	return s
+}
+
+// Tasks returns the currently running tasks, in the order they were submitted.
+// That includes tasks that ignored their canceled context and are still running without a slot.
+func (bg *BackgroundLimiter) Tasks() []TaskInfo { return bg.tracker.tasks() }
+
+// Stats returns a snapshot of the limiter's counters.
+func (bg *BackgroundLimiter) Stats() Stats { return bg.tracker.snapshot() }
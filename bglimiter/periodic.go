@@ -0,0 +1,108 @@
+package bglimiter
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/PathDNA/ptk"
+)
+
+// Overlap is what a periodic task does when it's due while the previous run is still running or waiting for a slot.
+type Overlap int
+
+const (
+	// OverlapSkip skips the run.
+	OverlapSkip Overlap = iota
+	// OverlapQueue runs it once more right after the current run returns, runs don't pile up beyond that.
+	OverlapQueue
+)
+
+// PeriodicOptions are passed to AddPeriodic.
+type PeriodicOptions struct {
+	// Interval between runs, the first run is one interval after AddPeriodic is called.
+	Interval time.Duration
+	// Jitter, if > 0, delays each run by a random duration up to Jitter.
+	Jitter  time.Duration
+	Overlap Overlap
+
+	// Task is used for every run.
+	Task TaskOptions
+
+	// Clock defaults to ptk.RealClock.
+	Clock ptk.Clock
+}
+
+// Every is an alias for AddPeriodic(PeriodicOptions{Interval: interval}, fn).
+func (bg *BackgroundLimiter) Every(interval time.Duration, fn func(ctx context.Context) error) (stop func()) {
+	return bg.AddPeriodic(PeriodicOptions{Interval: interval}, fn)
+}
+
+// AddPeriodic runs fn every opts.Interval under the limiter's limit until stop is called or the limiter is closed.
+// Calling stop doesn't cancel a run that's already started.
+func (bg *BackgroundLimiter) AddPeriodic(opts PeriodicOptions, fn func(ctx context.Context) error) (stop func()) {
+	if opts.Interval <= 0 {
+		panic("bglimiter: non-positive interval for AddPeriodic")
+	}
+
+	ctx, cancel := context.WithCancel(bg.ctx)
+	go bg.periodic(ctx, opts, fn)
+	return cancel
+}
+
+func (bg *BackgroundLimiter) periodic(ctx context.Context, opts PeriodicOptions, fn func(ctx context.Context) error) {
+	var (
+		clk    = ptk.ClockOrReal(opts.Clock)
+		next   = clk.Now()
+		done   = make(chan struct{}, 1)
+		busy   bool
+		queued bool
+	)
+
+	start := func() {
+		busy = true
+		go func() {
+			<-bg.run(ctx, opts.Task, false, fn)
+			done <- struct{}{}
+		}()
+	}
+
+	for {
+		now := clk.Now()
+		for next = next.Add(opts.Interval); !next.After(now); {
+			// we fell behind, don't try to catch up.
+			next = next.Add(opts.Interval)
+		}
+
+		wait := next.Sub(now)
+		if opts.Jitter > 0 {
+			wait += time.Duration(rand.Int63n(int64(opts.Jitter)))
+		}
+
+		t := clk.NewTimer(wait)
+
+	waitLoop:
+		for {
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				return
+
+			case <-done:
+				if busy = false; queued {
+					queued = false
+					start()
+				}
+
+			case <-t.C():
+				switch {
+				case !busy:
+					start()
+				case opts.Overlap == OverlapQueue:
+					queued = true
+				}
+				break waitLoop
+			}
+		}
+	}
+}
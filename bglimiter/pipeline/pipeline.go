@@ -0,0 +1,331 @@
+// Package pipeline builds typed multi-stage pipelines where every stage runs under its own bglimiter.BackgroundLimiter.
+// The first error, from any stage, cancels the whole pipeline and is returned by Wait.
+package pipeline
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/PathDNA/ptk/bglimiter"
+)
+
+// New returns a new pipeline with the given parent context.
+func New(ctx context.Context) *Pipeline {
+	var p Pipeline
+	p.ctx, p.cancel = context.WithCancel(ctx)
+	return &p
+}
+
+// Pipeline holds the shared state of all the stages.
+type Pipeline struct {
+	ctx    context.Context
+	cancel func()
+	wg     sync.WaitGroup
+
+	once sync.Once
+	err  error
+}
+
+// Context returns the pipeline's context, it's done once any stage fails.
+func (p *Pipeline) Context() context.Context { return p.ctx }
+
+// Wait waits for all the stages to exit and returns the first error.
+// The last stream must be consumed, by ForEach, Collect or reading Chan until it's closed, or Wait blocks forever.
+func (p *Pipeline) Wait() error {
+	p.wg.Wait()
+	p.fail(p.ctx.Err())
+	p.cancel()
+	return p.err
+}
+
+func (p *Pipeline) fail(err error) {
+	if err == nil {
+		return
+	}
+
+	p.once.Do(func() {
+		p.err = err
+		p.cancel()
+	})
+}
+
+func (p *Pipeline) goFn(fn func()) {
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		fn()
+	}()
+}
+
+// Stream is the output of a stage.
+type Stream[T any] struct {
+	p  *Pipeline
+	ch <-chan T
+}
+
+// Chan returns the stream's channel, it's closed once the stage is done.
+func (s Stream[T]) Chan() <-chan T { return s.ch }
+
+// Pipeline returns the pipeline the stream belongs to.
+func (s Stream[T]) Pipeline() *Pipeline { return s.p }
+
+// StageOptions are passed to Map and ForEach.
+type StageOptions struct {
+	// Limit is the max number of concurrent calls, defaults to 1.
+	Limit int
+	// Buffer is the size of the stage's output buffer.
+	Buffer int
+	// Ordered keeps the output in the same order as the input, otherwise items are sent as soon as they're ready.
+	Ordered bool
+}
+
+// Source runs fn in the background, emit returns false once the pipeline is canceled and fn should return.
+func Source[T any](p *Pipeline, buffer int, fn func(ctx context.Context, emit func(T) bool) error) Stream[T] {
+	out := make(chan T, buffer)
+
+	emit := func(v T) bool {
+		select {
+		case out <- v:
+			return true
+		case <-p.ctx.Done():
+			return false
+		}
+	}
+
+	p.goFn(func() {
+		defer close(out)
+		p.fail(fn(p.ctx, emit))
+	})
+
+	return Stream[T]{p, out}
+}
+
+// From returns a stream of the given items.
+func From[T any](p *Pipeline, items ...T) Stream[T] {
+	return Source(p, 0, func(ctx context.Context, emit func(T) bool) error {
+		for _, v := range items {
+			if !emit(v) {
+				break
+			}
+		}
+		return nil
+	})
+}
+
+// FromChan returns a stream of the items read from ch until it's closed.
+func FromChan[T any](p *Pipeline, ch <-chan T) Stream[T] {
+	return Source(p, 0, func(ctx context.Context, emit func(T) bool) error {
+		for v := range ch {
+			if !emit(v) {
+				break
+			}
+		}
+		return nil
+	})
+}
+
+// Map calls fn on every item of in, up to opts.Limit at once.
+func Map[I, O any](in Stream[I], opts StageOptions, fn func(ctx context.Context, v I) (O, error)) Stream[O] {
+	var (
+		p   = in.p
+		out = make(chan O, opts.Buffer)
+	)
+
+	if opts.Limit <= 0 {
+		opts.Limit = 1
+	}
+
+	var (
+		bg    = bglimiter.NewWithOptions(p.ctx, bglimiter.Options{Limit: opts.Limit, OnError: p.fail})
+		tasks sync.WaitGroup
+	)
+
+	type result struct {
+		v   chan O
+		err <-chan error
+	}
+
+	var pending chan result
+	if opts.Ordered {
+		pending = make(chan result, opts.Limit+opts.Buffer)
+
+		p.goFn(func() {
+			defer close(out)
+			for r := range pending {
+				if <-r.err != nil {
+					continue // the pipeline is canceled already, keep draining
+				}
+
+				select {
+				case out <- <-r.v:
+				case <-p.ctx.Done():
+				}
+			}
+		})
+	}
+
+	p.goFn(func() {
+		defer bg.Close()
+
+		for v := range in.ch {
+			if p.ctx.Err() != nil {
+				continue // drain in so upstream doesn't get stuck
+			}
+
+			v := v
+			if opts.Ordered {
+				res := make(chan O, 1)
+				pending <- result{res, spawn(p, bg, &tasks, func(ctx context.Context) error {
+					o, err := fn(ctx, v)
+					if err == nil {
+						res <- o
+					}
+					return err
+				})}
+				continue
+			}
+
+			spawn(p, bg, &tasks, func(ctx context.Context) error {
+				o, err := fn(ctx, v)
+				if err == nil {
+					select {
+					case out <- o:
+					case <-ctx.Done():
+					}
+				}
+				return err
+			})
+		}
+
+		if opts.Ordered {
+			close(pending)
+		}
+
+		// closing bg cancels anything still running, so wait for the tasks first.
+		tasks.Wait()
+		bg.Wait()
+		if !opts.Ordered {
+			close(out)
+		}
+	})
+
+	return Stream[O]{p, out}
+}
+
+// spawn runs fn under bg, wg tracks fn itself since bg stops waiting for it once the pipeline is canceled.
+func spawn(p *Pipeline, bg *bglimiter.BackgroundLimiter, wg *sync.WaitGroup, fn func(ctx context.Context) error) <-chan error {
+	var state int32
+
+	wg.Add(1)
+	errCh := bg.Add(func(ctx context.Context) error {
+		if !atomic.CompareAndSwapInt32(&state, 0, 1) {
+			return ctx.Err()
+		}
+		defer wg.Done()
+		return fn(ctx)
+	})
+
+	// bg.Add only fails once the pipeline is canceled, fn might never run then.
+	if p.ctx.Err() != nil && atomic.CompareAndSwapInt32(&state, 0, 2) {
+		wg.Done()
+	}
+
+	return errCh
+}
+
+// ForEach calls fn on every item of in, up to opts.Limit at once, opts.Buffer and opts.Ordered are ignored.
+func ForEach[T any](in Stream[T], opts StageOptions, fn func(ctx context.Context, v T) error) {
+	s := Map(in, StageOptions{Limit: opts.Limit}, func(ctx context.Context, v T) (struct{}, error) {
+		return struct{}{}, fn(ctx, v)
+	})
+
+	in.p.goFn(func() {
+		for range s.ch {
+		}
+	})
+}
+
+// Batch groups the items of in into slices of up to size items,
+// a partial batch is sent once timeout passes after its first item, if timeout is > 0.
+func Batch[T any](in Stream[T], size int, timeout time.Duration, buffer int) Stream[[]T] {
+	var (
+		p   = in.p
+		out = make(chan []T, buffer)
+	)
+
+	if size <= 0 {
+		size = 1
+	}
+
+	p.goFn(func() {
+		defer close(out)
+
+		var (
+			batch []T
+			timer *time.Timer
+			tc    <-chan time.Time
+		)
+
+		flush := func() bool {
+			if timer != nil {
+				timer.Stop()
+				timer, tc = nil, nil
+			}
+
+			if len(batch) == 0 {
+				return true
+			}
+
+			b := batch
+			batch = nil
+			select {
+			case out <- b:
+				return true
+			case <-p.ctx.Done():
+				return false
+			}
+		}
+
+		for {
+			select {
+			case v, ok := <-in.ch:
+				if !ok {
+					flush()
+					return
+				}
+
+				batch = append(batch, v)
+				if len(batch) == 1 && timeout > 0 {
+					timer = time.NewTimer(timeout)
+					tc = timer.C
+				}
+
+				if len(batch) >= size && !flush() {
+					return
+				}
+
+			case <-tc:
+				timer, tc = nil, nil
+				if !flush() {
+					return
+				}
+
+			case <-p.ctx.Done():
+				return
+			}
+		}
+	})
+
+	return Stream[[]T]{p, out}
+}
+
+// Collect reads all the items of s and returns them with the pipeline's error.
+func Collect[T any](s Stream[T]) ([]T, error) {
+	var out []T
+	for v := range s.ch {
+		out = append(out, v)
+	}
+	return out, s.p.Wait()
+}
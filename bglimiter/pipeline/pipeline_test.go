@@ -0,0 +1,122 @@
+package pipeline_test
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/PathDNA/ptk/bglimiter/pipeline"
+)
+
+func TestPipeline(t *testing.T) {
+	p := pipeline.New(context.Background())
+
+	nums := make([]int, 100)
+	for i := range nums {
+		nums[i] = i
+	}
+
+	var cur, max int64
+	sq := pipeline.Map(pipeline.From(p, nums...), pipeline.StageOptions{Limit: 4, Buffer: 2, Ordered: true}, func(ctx context.Context, v int) (int, error) {
+		n := atomic.AddInt64(&cur, 1)
+		defer atomic.AddInt64(&cur, -1)
+		for m := atomic.LoadInt64(&max); n > m && !atomic.CompareAndSwapInt64(&max, m, n); m = atomic.LoadInt64(&max) {
+		}
+		time.Sleep(time.Duration(100-v) * 10 * time.Microsecond) // later items finish first
+		return v * v, nil
+	})
+
+	strs := pipeline.Map(sq, pipeline.StageOptions{Limit: 2}, func(ctx context.Context, v int) (string, error) {
+		return strconv.Itoa(v), nil
+	})
+
+	out, err := pipeline.Collect(strs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(out) != 100 {
+		t.Fatalf("expected 100 items, got %d", len(out))
+	}
+
+	if m := atomic.LoadInt64(&max); m > 4 {
+		t.Fatalf("limit not respected: %d", m)
+	}
+
+	p = pipeline.New(context.Background())
+	sq = pipeline.Map(pipeline.From(p, nums...), pipeline.StageOptions{Limit: 8, Ordered: true}, func(ctx context.Context, v int) (int, error) {
+		time.Sleep(time.Duration(v%7) * 100 * time.Microsecond)
+		return v * v, nil
+	})
+	ints, err := pipeline.Collect(sq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sort.IntsAreSorted(ints) || len(ints) != 100 {
+		t.Fatalf("ordered output isn't in order: %v", ints)
+	}
+}
+
+func TestPipelineError(t *testing.T) {
+	var (
+		p    = pipeline.New(context.Background())
+		boom = errors.New("boom")
+		sent int64
+	)
+
+	src := pipeline.Source(p, 0, func(ctx context.Context, emit func(int) bool) error {
+		for i := 0; ; i++ {
+			if !emit(i) {
+				return nil
+			}
+			atomic.AddInt64(&sent, 1)
+		}
+	})
+
+	pipeline.ForEach(src, pipeline.StageOptions{Limit: 2}, func(ctx context.Context, v int) error {
+		if v == 10 {
+			return boom
+		}
+		return nil
+	})
+
+	if err := p.Wait(); err != boom {
+		t.Fatalf("expected boom, got %v", err)
+	}
+
+	if n := atomic.LoadInt64(&sent); n > 100 {
+		t.Fatalf("upstream wasn't canceled: %d", n)
+	}
+}
+
+func TestBatch(t *testing.T) {
+	p := pipeline.New(context.Background())
+
+	ch := make(chan int)
+	go func() {
+		for i := 0; i < 7; i++ {
+			ch <- i
+		}
+		time.Sleep(50 * time.Millisecond) // the timeout should flush the partial batch
+		ch <- 7
+		close(ch)
+	}()
+
+	batches, err := pipeline.Collect(pipeline.Batch(pipeline.FromChan(p, ch), 3, 10*time.Millisecond, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sizes []int
+	for _, b := range batches {
+		sizes = append(sizes, len(b))
+	}
+
+	if len(sizes) != 4 || sizes[0] != 3 || sizes[1] != 3 || sizes[2] != 1 || sizes[3] != 1 {
+		t.Fatalf("unexpected batches: %v", batches)
+	}
+}
@@ -0,0 +1,149 @@
+package bglimiter
+
+import (
+	"container/list"
+	"sort"
+)
+
+// Priority of a task, tasks with a higher priority always get a slot before lower ones.
+type Priority int
+
+const (
+	PriorityLow    Priority = -10
+	PriorityNormal Priority = 0
+	PriorityHigh   Priority = 10
+)
+
+type waiter struct {
+	ready chan struct{}
+	err   error
+
+	lvl  *level
+	tq   *tenantQueue
+	elem *list.Element
+}
+
+// waitQueue orders waiters by priority, then round-robins between tenants of the same priority
+// giving each tenant up to its weight of slots per turn.
+type waitQueue struct {
+	levels  map[Priority]*level
+	prios   []Priority // sorted high to low
+	weights map[string]int
+	n       int
+}
+
+func (q *waitQueue) Len() int { return q.n }
+
+func (q *waitQueue) push(pri Priority, tenant string) *waiter {
+	if q.levels == nil {
+		q.levels = map[Priority]*level{}
+	}
+
+	lvl := q.levels[pri]
+	if lvl == nil {
+		lvl = &level{pri: pri, tenants: map[string]*tenantQueue{}}
+		q.levels[pri] = lvl
+		q.prios = append(q.prios, pri)
+		sort.Slice(q.prios, func(i, j int) bool { return q.prios[i] > q.prios[j] })
+	}
+
+	tq := lvl.tenants[tenant]
+	if tq == nil {
+		tq = &tenantQueue{name: tenant}
+		lvl.tenants[tenant] = tq
+		lvl.ring = append(lvl.ring, tq)
+	}
+
+	w := &waiter{ready: make(chan struct{}), lvl: lvl, tq: tq}
+	w.elem = tq.waiters.PushBack(w)
+	lvl.n++
+	q.n++
+
+	return w
+}
+
+func (q *waitQueue) pop() *waiter {
+	for _, pri := range q.prios {
+		lvl := q.levels[pri]
+		if lvl.n == 0 {
+			continue
+		}
+
+		tq := lvl.ring[lvl.pos]
+		w := tq.waiters.Front().Value.(*waiter)
+
+		// move to the next tenant once this one used up its turn
+		if lvl.used++; lvl.used >= q.weight(tq.name) || tq.waiters.Len() == 1 {
+			lvl.used = 0
+			lvl.pos++
+		}
+
+		q.remove(w)
+		return w
+	}
+
+	return nil
+}
+
+func (q *waitQueue) remove(w *waiter) {
+	lvl, tq := w.lvl, w.tq
+	tq.waiters.Remove(w.elem)
+	lvl.n--
+	q.n--
+
+	if tq.waiters.Len() > 0 {
+		if lvl.pos >= len(lvl.ring) {
+			lvl.pos = 0
+		}
+		return
+	}
+
+	// drop empty tenants so idle ones don't take memory or turns
+	delete(lvl.tenants, tq.name)
+	for i, t := range lvl.ring {
+		if t == tq {
+			lvl.ring = append(lvl.ring[:i], lvl.ring[i+1:]...)
+			if i < lvl.pos {
+				lvl.pos--
+			} else if i == lvl.pos {
+				lvl.used = 0
+			}
+			break
+		}
+	}
+
+	if lvl.pos >= len(lvl.ring) {
+		lvl.pos = 0
+	}
+
+	if lvl.n == 0 {
+		delete(q.levels, lvl.pri)
+		for i, p := range q.prios {
+			if p == lvl.pri {
+				q.prios = append(q.prios[:i], q.prios[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+func (q *waitQueue) weight(tenant string) int {
+	if w := q.weights[tenant]; w > 0 {
+		return w
+	}
+	return 1
+}
+
+type level struct {
+	pri     Priority
+	tenants map[string]*tenantQueue
+	ring    []*tenantQueue
+	pos     int
+	used    int
+	n       int
+}
+
+type tenantQueue struct {
+	name    string
+	waiters list.List
+}
@@ -0,0 +1,69 @@
+package cache_test
+
+import (
+	"math/rand"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/PathDNA/ptk/cache"
+)
+
+type benchCache interface {
+	Set(key string, val interface{}, ttl time.Duration) error
+	Get(key string) (interface{}, bool)
+}
+
+const benchKeys = 1 << 14
+
+var keys = func() []string {
+	out := make([]string, benchKeys)
+	for i := range out {
+		out[i] = "key:" + strconv.Itoa(i)
+	}
+	return out
+}()
+
+func benchMixed(b *testing.B, c benchCache, writePct int) {
+	for _, k := range keys {
+		c.Set(k, k, time.Hour)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		r := rand.New(rand.NewSource(rand.Int63()))
+		for pb.Next() {
+			k := keys[r.Intn(benchKeys)]
+			if r.Intn(100) < writePct {
+				c.Set(k, k, time.Hour)
+			} else {
+				c.Get(k)
+			}
+		}
+	})
+}
+
+func BenchmarkMixed(b *testing.B) {
+	for _, writePct := range []int{1, 10, 50} {
+		name := strconv.Itoa(writePct) + "%write"
+
+		b.Run("MemCache/"+name, func(b *testing.B) {
+			c := cache.NewMemCache(0)
+			defer c.Close()
+			benchMixed(b, c, writePct)
+		})
+
+		b.Run("Sharded/"+name, func(b *testing.B) {
+			c := cache.NewShardedMemCache(0, cache.Options{})
+			defer c.Close()
+			benchMixed(b, c, writePct)
+		})
+
+		b.Run("ShardedLRU/"+name, func(b *testing.B) {
+			c := cache.NewShardedMemCache(0, cache.Options{MaxEntries: benchKeys / 2})
+			defer c.Close()
+			benchMixed(b, c, writePct)
+		})
+	}
+}
@@ -0,0 +1,397 @@
+package cache
+
+import (
+	"container/heap"
+	"context"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/PathDNA/ptk"
+	"github.com/PathDNA/ptk/bglimiter"
+)
+
+// Options are passed to New and NewMemCacheWithOptions.
+type Options struct {
+	// AutoCleanEvery, if > 0, calls Clean every AutoCleanEvery.
+	AutoCleanEvery time.Duration
+
+	// Clock defaults to ptk.RealClock.
+	Clock ptk.Clock
+
+	// MaxEntries, if > 0, is the max number of entries, the ones picked by EvictionPolicy are evicted after that.
+	MaxEntries int
+	// MaxBytes, if > 0, is the max total size of the entries as returned by the sizer passed to Cache.SetSizer.
+	MaxBytes int64
+	// EvictionPolicy defaults to EvictLRU.
+	EvictionPolicy EvictionPolicy
+
+	// NegativeTTL, if > 0, caches GetOrLoad errors for that long.
+	NegativeTTL time.Duration
+
+	// RefreshLimiter runs the background refreshes of SetWithRefresh entries,
+	// refreshes are skipped while it's at its limit. Defaults to a limiter with a limit of 4 that's closed with the cache.
+	RefreshLimiter *bglimiter.BackgroundLimiter
+
+	// MaxQueuedEvents is the max number of events waiting to be delivered to hooks and watchers,
+	// new events are dropped while the queue is full. Defaults to 65536.
+	MaxQueuedEvents int
+}
+
+// New returns a new Cache with the given options.
+func New[K comparable, V any](opts Options) (c *Cache[K, V]) {
+	c = &Cache[K, V]{
+		c:     map[K]*cacheItem[K, V]{},
+		done:  make(chan struct{}, 1),
+		clock: ptk.ClockOrReal(opts.Clock),
+
+		maxEntries:  opts.MaxEntries,
+		maxBytes:    opts.MaxBytes,
+		negativeTTL: opts.NegativeTTL,
+		refresher:   opts.RefreshLimiter,
+	}
+
+	c.hooks.max = opts.MaxQueuedEvents
+	if c.hooks.max <= 0 {
+		c.hooks.max = 1 << 16
+	}
+
+	if c.refresher == nil {
+		c.refresher = bglimiter.NewWithContext(context.Background(), 4)
+		c.ownRefresher = true
+	}
+
+	if c.maxEntries > 0 || c.maxBytes > 0 {
+		c.policy = newPolicy[K](opts.EvictionPolicy, opts.MaxEntries)
+	}
+
+	if opts.AutoCleanEvery > 0 {
+		t := c.clock.NewTicker(opts.AutoCleanEvery)
+		go func() {
+			defer t.Stop()
+			for {
+				select {
+				case <-c.done:
+					return
+				case <-t.C():
+					c.Clean()
+				}
+			}
+		}()
+	}
+
+	return
+}
+
+// Cache is an in-memory cache with per-key locking.
+type Cache[K comparable, V any] struct {
+	c     map[K]*cacheItem[K, V]
+	done  chan struct{}
+	clock ptk.Clock
+	// using double mutexes to handle long updates
+	mux  sync.RWMutex
+	mmux multiMux[K]
+	exp  expiryHeap[K, V]
+
+	maxEntries int
+	maxBytes   int64
+	bytes      int64
+	sizer      func(key K, val V) int64
+	onEvict    func(key K, val V)
+	hooks      hooks[K, V]
+
+	pmux   sync.Mutex // guards policy's state, always locked after mux
+	policy policy[K]  // only set by New, so it can be checked for nil without pmux
+
+	negativeTTL time.Duration
+	lmux        sync.Mutex
+	loads       map[K]*loadCall[V]
+
+	refresher    *bglimiter.BackgroundLimiter
+	ownRefresher bool
+}
+
+// SetSizer sets the function used to estimate the size of entries for Options.MaxBytes,
+// it should be called before adding any entries.
+func (c *Cache[K, V]) SetSizer(fn func(key K, val V) int64) {
+	c.mux.Lock()
+	c.sizer = fn
+	c.mux.Unlock()
+}
+
+// OnEvict sets a function that gets called with every entry evicted to stay under the limits.
+// Unlike the hooks added by OnEvictEvent, it's called synchronously, right after the cache's locks are released.
+func (c *Cache[K, V]) OnEvict(fn func(key K, val V)) {
+	c.mux.Lock()
+	c.onEvict = fn
+	c.mux.Unlock()
+}
+
+func (c *Cache[K, V]) Set(key K, val V, ttl time.Duration) (err error) {
+	var ev []evicted[K, V]
+	c.mmux.Update(key, func() {
+		c.mux.Lock()
+		if c.c == nil {
+			err = os.ErrClosed
+		} else {
+			ev = c.store(key, &cacheItem[K, V]{Value: val, ExpiresAt: c.clock.Now().Add(ttl).UnixNano()})
+		}
+		c.mux.Unlock()
+	})
+	c.notifyEvicted(ev)
+
+	return
+}
+
+// if ttl is -1, the key gets deleted
+// if keepOldTTL is true, the original expiry ts will be kept
+// old is the zero value if the key doesn't exist.
+func (c *Cache[K, V]) Update(key K, fn func(old V) (val V, keepOldTTL bool, ttl time.Duration)) (err error) {
+	var ev []evicted[K, V]
+	c.mmux.Update(key, func() {
+		var old V
+
+		c.mux.RLock()
+		if ci := c.c[key]; ci != nil {
+			old = ci.Value
+		}
+		c.mux.RUnlock()
+
+		val, keepOldTTL, ttl := fn(old)
+
+		c.mux.Lock()
+		defer c.mux.Unlock()
+		if c.c == nil {
+			err = os.ErrClosed
+		} else if ttl == -1 {
+			c.drop(key, ReasonDelete)
+		} else {
+			ci := &cacheItem[K, V]{Value: val, ExpiresAt: c.clock.Now().Add(ttl).UnixNano()}
+			if old := c.c[key]; keepOldTTL && old != nil {
+				ci.ExpiresAt = old.ExpiresAt
+			}
+			ev = c.store(key, ci)
+		}
+	})
+	c.notifyEvicted(ev)
+
+	return
+}
+
+func (c *Cache[K, V]) Delete(key K) (err error) {
+	c.mmux.Update(key, func() {
+		c.mux.Lock()
+		if c.c == nil {
+			err = os.ErrClosed
+		} else {
+			c.drop(key, ReasonDelete)
+		}
+		c.mux.Unlock()
+	})
+	return
+}
+
+func (c *Cache[K, V]) Get(key K) (val V, found bool) {
+	var (
+		ci  *cacheItem[K, V]
+		now = c.clock.Now().UnixNano()
+	)
+
+	c.mmux.Read(key, func() {
+		c.mux.RLock()
+		if ci, found = c.c[key]; found && ci.err == nil && now <= ci.ExpiresAt {
+			val = ci.Value
+		} else {
+			found = false
+		}
+		c.mux.RUnlock()
+	})
+
+	if !found {
+		return
+	}
+
+	if c.policy != nil {
+		c.pmux.Lock()
+		c.policy.touch(key)
+		c.pmux.Unlock()
+	}
+
+	c.maybeRefresh(key, ci, now)
+
+	return
+}
+
+// Clean removes the expired entries, it only looks at the expired ones so it's cheap to call often.
+func (c *Cache[K, V]) Clean() (n int) {
+	now := c.clock.Now().UnixNano()
+	c.mux.Lock()
+	for len(c.exp) > 0 && now > c.exp[0].ExpiresAt {
+		c.drop(c.exp[0].key, ReasonExpire)
+		n++
+	}
+	c.mux.Unlock()
+
+	return
+}
+
+// Reset removes all the entries, hooks and watchers get a single ReasonReset event.
+func (c *Cache[K, V]) Reset() {
+	c.mux.Lock()
+	c.hooks.emit(Event[K, V]{Reason: ReasonReset})
+	c.c = map[K]*cacheItem[K, V]{}
+	c.exp = nil
+	c.bytes = 0
+	if c.policy != nil {
+		c.pmux.Lock()
+		c.policy.reset()
+		c.pmux.Unlock()
+	}
+	c.mux.Unlock()
+}
+
+// Len returns the number of entries, including expired ones that weren't cleaned yet.
+func (c *Cache[K, V]) Len() int {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+	return len(c.c)
+}
+
+// Bytes returns the total size of the entries, it's always 0 without a sizer.
+func (c *Cache[K, V]) Bytes() int64 {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+	return c.bytes
+}
+
+type evicted[K comparable, V any] struct {
+	key K
+	val V
+}
+
+// store must be called with c.mux held, it returns the entries evicted to make room.
+func (c *Cache[K, V]) store(key K, ci *cacheItem[K, V]) (ev []evicted[K, V]) {
+	if c.sizer != nil {
+		ci.size = c.sizer(key, ci.Value)
+	}
+
+	// negative GetOrLoad results don't fire hooks.
+	e := Event[K, V]{Reason: ReasonSet, Key: key, Value: ci.Value}
+	if old := c.c[key]; old != nil {
+		c.bytes -= old.size
+		heap.Remove(&c.exp, old.hidx)
+		if old.err == nil {
+			e.Reason, e.Old = ReasonUpdate, old.Value
+		}
+	}
+	if ci.err == nil {
+		c.hooks.emit(e)
+	}
+	ci.key = key
+	c.c[key] = ci
+	c.bytes += ci.size
+	heap.Push(&c.exp, ci)
+
+	if c.policy == nil {
+		return
+	}
+
+	c.pmux.Lock()
+	defer c.pmux.Unlock()
+
+	c.policy.add(key)
+	for (c.maxEntries > 0 && len(c.c) > c.maxEntries) || (c.maxBytes > 0 && c.bytes > c.maxBytes) {
+		k, ok := c.policy.victim()
+		if !ok {
+			break
+		}
+
+		if ci := c.c[k]; ci != nil {
+			delete(c.c, k)
+			c.bytes -= ci.size
+			heap.Remove(&c.exp, ci.hidx)
+			if ci.err == nil {
+				c.hooks.emit(Event[K, V]{Reason: ReasonEvict, Key: k, Value: ci.Value})
+				if c.onEvict != nil {
+					ev = append(ev, evicted[K, V]{k, ci.Value})
+				}
+			}
+		}
+	}
+
+	return
+}
+
+// drop must be called with c.mux held.
+func (c *Cache[K, V]) drop(key K, reason Reason) {
+	ci := c.c[key]
+	if ci == nil {
+		return
+	}
+
+	delete(c.c, key)
+	c.bytes -= ci.size
+	heap.Remove(&c.exp, ci.hidx)
+	if ci.err == nil {
+		c.hooks.emit(Event[K, V]{Reason: reason, Key: key, Value: ci.Value})
+	}
+
+	if c.policy != nil {
+		c.pmux.Lock()
+		c.policy.remove(key)
+		c.pmux.Unlock()
+	}
+}
+
+func (c *Cache[K, V]) notifyEvicted(ev []evicted[K, V]) {
+	if len(ev) == 0 {
+		return
+	}
+
+	c.mux.RLock()
+	fn := c.onEvict
+	c.mux.RUnlock()
+
+	for _, e := range ev {
+		fn(e.key, e.val)
+	}
+}
+
+func (c *Cache[K, V]) Close() error {
+	select {
+	case <-c.done:
+		return os.ErrClosed
+	default:
+	}
+
+	c.mux.Lock()
+	close(c.done)
+	c.c = nil
+	c.exp = nil
+	c.mux.Unlock()
+
+	if c.ownRefresher {
+		c.refresher.Close()
+	}
+
+	c.hooks.close()
+
+	return nil
+}
+
+type cacheItem[K comparable, V any] struct {
+	Value     V
+	ExpiresAt int64 // unix nanos
+
+	key  K
+	hidx int // index in Cache.exp
+
+	size int64
+	err  error // negative GetOrLoad result
+
+	// set by SetWithRefresh
+	staleAt          int64
+	softTTL, hardTTL time.Duration
+	refresh          func(ctx context.Context) (V, error)
+	refreshing       int32
+}
@@ -0,0 +1,685 @@
+package cache_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/PathDNA/ptk"
+	"github.com/PathDNA/ptk/cache"
+)
+
+func TestMemCache(t *testing.T) {
+	clk := ptk.NewFakeClock(time.Time{})
+	mc := cache.NewMemCacheWithOptions(cache.Options{Clock: clk})
+
+	if k := cache.Key("a", 1, true); k != "a:1:true" {
+		t.Fatalf("unexpected key: %q", k)
+	}
+
+	mc.Set("a", 1, time.Minute)
+	if v, ok := mc.Get("a"); !ok || v != 1 {
+		t.Fatalf("unexpected value: %v %v", v, ok)
+	}
+
+	clk.Advance(50 * time.Second)
+	mc.Update("a", func(old interface{}) (interface{}, bool, time.Duration) {
+		return old.(int) + 1, true, 0
+	})
+	mc.Update("b", func(old interface{}) (interface{}, bool, time.Duration) {
+		if old != nil {
+			t.Fatalf("expected a nil old value, got %v", old)
+		}
+		// keepOldTTL is ignored for new keys
+		return 10, true, time.Minute
+	})
+
+	if v, ok := mc.Get("a"); !ok || v != 2 {
+		t.Fatalf("unexpected value: %v %v", v, ok)
+	}
+
+	clk.Advance(11 * time.Second)
+	if _, ok := mc.Get("a"); ok {
+		t.Fatal("a should've kept its original ttl and expired")
+	}
+
+	if v, ok := mc.Get("b"); !ok || v != 10 {
+		t.Fatalf("unexpected value: %v %v", v, ok)
+	}
+
+	mc.Update("b", func(old interface{}) (interface{}, bool, time.Duration) { return nil, false, -1 })
+	if _, ok := mc.Get("b"); ok || mc.Len() != 1 {
+		t.Fatalf("a ttl of -1 should delete b, %d entries left", mc.Len())
+	}
+
+	mc.Set("c", 3, time.Minute)
+	if err := mc.Delete("c"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := mc.Get("c"); ok {
+		t.Fatal("c should be deleted")
+	}
+
+	if err := mc.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mc.Close(); err != os.ErrClosed {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+
+	if mc.Set("d", 4, time.Minute) != os.ErrClosed || mc.Delete("d") != os.ErrClosed ||
+		mc.Update("d", func(interface{}) (interface{}, bool, time.Duration) { return 4, false, time.Minute }) != os.ErrClosed {
+		t.Fatal("expected ErrClosed after Close")
+	}
+
+	if _, ok := mc.Get("d"); ok {
+		t.Fatal("Get shouldn't find anything after Close")
+	}
+}
+
+func TestEviction(t *testing.T) {
+	for _, p := range []cache.EvictionPolicy{cache.EvictLRU, cache.EvictLFU, cache.EvictTinyLFU} {
+		c := cache.New[int, string](cache.Options{MaxEntries: 10, EvictionPolicy: p})
+
+		var (
+			evicted []int
+			events  int64
+		)
+		c.OnEvict(func(k int, v string) { evicted = append(evicted, k) })
+		c.OnEvictEvent(func(e cache.Event[int, string]) { atomic.AddInt64(&events, 1) })
+
+		for i := 0; i < 10; i++ {
+			c.Set(i, strconv.Itoa(i), time.Minute)
+		}
+
+		// make 0-4 hot
+		for n := 0; n < 5; n++ {
+			for i := 0; i < 5; i++ {
+				c.Get(i)
+			}
+		}
+
+		for i := 10; i < 30; i++ {
+			c.Set(i, strconv.Itoa(i), time.Minute)
+		}
+
+		if c.Len() != 10 || len(evicted) != 20 {
+			t.Fatalf("policy %d: expected 10 entries and 20 evictions, got %d and %d", p, c.Len(), len(evicted))
+		}
+
+		// event hooks are async
+		for i := 0; i < 500 && atomic.LoadInt64(&events) != 20; i++ {
+			time.Sleep(time.Millisecond)
+		}
+
+		if n := atomic.LoadInt64(&events); n != 20 {
+			t.Fatalf("policy %d: expected 20 evict events, got %d", p, n)
+		}
+
+		if p != cache.EvictLRU {
+			for i := 0; i < 5; i++ {
+				if _, ok := c.Get(i); !ok {
+					t.Fatalf("policy %d: hot key %d got evicted", p, i)
+				}
+			}
+		}
+	}
+
+	c := cache.New[string, []byte](cache.Options{MaxBytes: 100})
+	c.SetSizer(func(k string, v []byte) int64 { return int64(len(k) + len(v)) })
+	for i := 0; i < 20; i++ {
+		c.Set(strconv.Itoa(i), make([]byte, 18), time.Minute) // 20 bytes each
+	}
+
+	if c.Bytes() != 100 || c.Len() != 5 {
+		t.Fatalf("expected 100 bytes in 5 entries, got %d in %d", c.Bytes(), c.Len())
+	}
+
+	if _, ok := c.Get("19"); !ok {
+		t.Fatal("the last key should've been kept")
+	}
+}
+
+func TestReset(t *testing.T) {
+	for _, p := range []cache.EvictionPolicy{cache.EvictLRU, cache.EvictLFU, cache.EvictTinyLFU} {
+		c := cache.New[int, int](cache.Options{MaxEntries: 10, EvictionPolicy: p})
+
+		var wg sync.WaitGroup
+		for g := 0; g < 4; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				for i := 0; i < 500; i++ {
+					if g == 0 {
+						c.Reset()
+						continue
+					}
+					c.Set(g*100+i%10, i, time.Minute)
+					c.Get(g*100 + i%10)
+				}
+			}(g)
+		}
+		wg.Wait()
+
+		c.Reset()
+		if c.Len() != 0 {
+			t.Fatalf("policy %d: expected an empty cache after Reset, got %d entries", p, c.Len())
+		}
+
+		// the policy should keep working after a Reset.
+		for i := 0; i < 30; i++ {
+			c.Set(i, i, time.Minute)
+		}
+
+		if c.Len() != 10 {
+			t.Fatalf("policy %d: expected 10 entries, got %d", p, c.Len())
+		}
+		c.Close()
+	}
+}
+
+func TestGetOrLoad(t *testing.T) {
+	c := cache.New[string, int](cache.Options{NegativeTTL: time.Minute})
+	defer c.Close()
+
+	var (
+		calls int64
+		wg    sync.WaitGroup
+		boom  = errors.New("boom")
+	)
+
+	loader := func(ctx context.Context) (int, error) {
+		atomic.AddInt64(&calls, 1)
+		time.Sleep(20 * time.Millisecond)
+		return 42, nil
+	}
+
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v, err := c.GetOrLoad(context.Background(), "k", loader, time.Minute); v != 42 || err != nil {
+				t.Errorf("unexpected result: %v %v", v, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := atomic.LoadInt64(&calls); n != 1 {
+		t.Fatalf("expected 1 load, got %d", n)
+	}
+
+	failing := func(ctx context.Context) (int, error) {
+		atomic.AddInt64(&calls, 1)
+		time.Sleep(20 * time.Millisecond)
+		return 0, boom
+	}
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.GetOrLoad(context.Background(), "bad", failing, time.Minute); err != boom {
+				t.Errorf("expected boom, got %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// the error is cached now.
+	if _, err := c.GetOrLoad(context.Background(), "bad", failing, time.Minute); err != boom {
+		t.Fatalf("expected boom, got %v", err)
+	}
+
+	if n := atomic.LoadInt64(&calls); n != 2 {
+		t.Fatalf("expected 2 loads, got %d", n)
+	}
+
+	if _, ok := c.Get("bad"); ok {
+		t.Fatal("negative entries shouldn't be returned by Get")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	if _, err := c.GetOrLoad(ctx, "slow", loader, time.Minute); err != context.DeadlineExceeded {
+		t.Fatalf("expected DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestGetOrLoadPanic(t *testing.T) {
+	c := cache.New[string, int](cache.Options{})
+	defer c.Close()
+
+	var (
+		wg      sync.WaitGroup
+		release = make(chan struct{})
+	)
+
+	loader := func(ctx context.Context) (int, error) {
+		<-release
+		panic("boom")
+	}
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var pe *ptk.PanicError
+			if _, err := c.GetOrLoad(context.Background(), "k", loader, time.Minute); !errors.As(err, &pe) || pe.Value != "boom" {
+				t.Errorf("expected a *PanicError, got %v", err)
+			}
+		}()
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+}
+
+func TestGetOrLoadUnlocked(t *testing.T) {
+	c := cache.New[string, int](cache.Options{})
+	defer c.Close()
+
+	var (
+		started = make(chan struct{})
+		release = make(chan struct{})
+		res     = make(chan int, 1)
+	)
+
+	go func() {
+		v, _ := c.GetOrLoad(context.Background(), "k", func(ctx context.Context) (int, error) {
+			close(started)
+			<-release
+			return 1, nil
+		}, time.Minute)
+		res <- v
+	}()
+	<-started
+
+	// none of these should wait for the loader.
+	done := make(chan struct{})
+	go func() {
+		c.Get("k")
+		c.Delete("k")
+		c.Set("k", 2, time.Minute)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the loader is blocking other calls on its key")
+	}
+
+	close(release)
+	if v := <-res; v != 2 {
+		t.Fatalf("expected the value set while loading to win, got %d", v)
+	}
+
+	if v, _ := c.Get("k"); v != 2 {
+		t.Fatalf("expected 2, got %d", v)
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	clk := ptk.NewFakeClock(time.Unix(1000, 0))
+	c := cache.New[string, int](cache.Options{Clock: clk})
+	defer c.Close()
+
+	var (
+		calls   int64
+		release = make(chan struct{})
+	)
+
+	refresh := func(ctx context.Context) (int, error) {
+		<-release
+		return int(atomic.AddInt64(&calls, 1)) + 1, nil
+	}
+
+	c.SetWithRefresh("k", 1, time.Second, 10*time.Second, refresh)
+
+	if v, _ := c.Get("k"); v != 1 {
+		t.Fatalf("expected 1, got %d", v)
+	}
+
+	clk.Advance(2 * time.Second)
+
+	// stale, but still served while a single refresh runs.
+	for i := 0; i < 10; i++ {
+		if v, ok := c.Get("k"); !ok || v != 1 {
+			t.Fatalf("expected the stale value, got %v %v", v, ok)
+		}
+	}
+	close(release)
+
+	for i := 0; i < 100; i++ {
+		if v, _ := c.Get("k"); v == 2 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if v, _ := c.Get("k"); v != 2 || atomic.LoadInt64(&calls) != 1 {
+		t.Fatalf("expected a single refresh, got %d calls and %d", atomic.LoadInt64(&calls), v)
+	}
+
+	clk.Advance(11 * time.Second)
+	if _, ok := c.Get("k"); ok {
+		t.Fatal("expected a miss after the hard ttl")
+	}
+
+	// a refresh that panics or fails shouldn't stop the next Get from trying again.
+	atomic.StoreInt64(&calls, 0)
+	c.SetWithRefresh("p", 1, time.Second, time.Hour, func(ctx context.Context) (int, error) {
+		switch atomic.AddInt64(&calls, 1) {
+		case 1:
+			panic("boom")
+		case 2:
+			return 0, errors.New("nope")
+		default:
+			return 2, nil
+		}
+	})
+	clk.Advance(2 * time.Second)
+
+	for i := 0; i < 500; i++ {
+		if v, _ := c.Get("p"); v == 2 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if v, _ := c.Get("p"); v != 2 || atomic.LoadInt64(&calls) != 3 {
+		t.Fatalf("expected the 3rd refresh to succeed, got %d calls and %d", atomic.LoadInt64(&calls), v)
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	clk := ptk.NewFakeClock(time.Unix(1000, 0))
+	c := cache.NewMemCacheWithOptions(cache.Options{Clock: clk, AutoCleanEvery: time.Second})
+	defer c.Close()
+
+	waitLen := func(n int) {
+		t.Helper()
+		for i := 0; i < 500 && c.Len() != n; i++ {
+			time.Sleep(time.Millisecond)
+		}
+		if c.Len() != n {
+			t.Fatalf("expected %d entries, got %d", n, c.Len())
+		}
+	}
+
+	c.Set("short", 1, 100*time.Millisecond)
+	c.Set("long", 2, time.Hour)
+
+	clk.Advance(50 * time.Millisecond)
+	if _, ok := c.Get("short"); !ok {
+		t.Fatal("expected a hit")
+	}
+
+	clk.Advance(51 * time.Millisecond)
+	if _, ok := c.Get("short"); ok {
+		t.Fatal("expired entries should be a miss")
+	}
+
+	// the sweeper should keep running, not just once.
+	for i := 0; i < 3; i++ {
+		c.Set("k"+strconv.Itoa(i), i, 500*time.Millisecond)
+		clk.Advance(time.Second)
+		waitLen(1)
+	}
+
+	if n := c.Clean(); n != 0 {
+		t.Fatalf("expected nothing to clean, got %d", n)
+	}
+
+	if v, ok := c.Get("long"); !ok || v != 2 {
+		t.Fatalf("unexpected value: %v %v", v, ok)
+	}
+}
+
+func TestSharded(t *testing.T) {
+	c := cache.NewSharded[int, int](8, cache.Options{MaxEntries: 800})
+	defer c.Close()
+
+	for i := 0; i < 2000; i++ {
+		c.Set(i, i*2, time.Minute)
+	}
+
+	if n := c.Len(); n > 800 || n < 600 {
+		t.Fatalf("unexpected number of entries: %d", n)
+	}
+
+	if v, ok := c.Get(1999); !ok || v != 3998 {
+		t.Fatalf("unexpected value: %v %v", v, ok)
+	}
+
+	c.Update(1999, func(old int) (int, bool, time.Duration) { return old + 1, true, 0 })
+	if v, _ := c.Get(1999); v != 3999 {
+		t.Fatalf("unexpected value: %v", v)
+	}
+
+	c.Delete(1999)
+	if _, ok := c.Get(1999); ok {
+		t.Fatal("expected a miss")
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Set(1, 1, time.Minute); err == nil {
+		t.Fatal("expected an error after Close")
+	}
+}
+
+func TestShardedLimits(t *testing.T) {
+	for _, tc := range []struct{ shards, max int }{{0, 10}, {0, 1}, {64, 100}, {8, 801}} {
+		c := cache.NewSharded[int, int](tc.shards, cache.Options{MaxEntries: tc.max})
+		for i := 0; i < 2000; i++ {
+			c.Set(i, i, time.Minute)
+		}
+
+		if n := c.Len(); n > tc.max || n == 0 {
+			t.Fatalf("%d shards: expected at most %d entries, got %d", tc.shards, tc.max, n)
+		}
+		c.Close()
+	}
+
+	c := cache.NewSharded[int, []byte](0, cache.Options{MaxBytes: 100})
+	defer c.Close()
+	c.SetSizer(func(k int, v []byte) int64 { return int64(len(v)) })
+	for i := 0; i < 2000; i++ {
+		c.Set(i, make([]byte, 1+i%10), time.Minute)
+	}
+
+	if n := c.Bytes(); n > 100 {
+		t.Fatalf("expected at most 100 bytes, got %d", n)
+	}
+}
+
+func TestHooks(t *testing.T) {
+	clk := ptk.NewFakeClock(time.Unix(1000, 0))
+	c := cache.NewMemCacheWithOptions(cache.Options{Clock: clk})
+
+	var (
+		mux    sync.Mutex
+		events []string
+	)
+
+	hook := func(e cache.Event[string, interface{}]) {
+		mux.Lock()
+		events = append(events, e.Reason.String()+":"+e.Key)
+		mux.Unlock()
+	}
+
+	c.OnSet(hook)
+	c.OnDelete(hook)
+	c.OnExpire(hook)
+
+	ch, stop := c.Watch(cache.Prefix("user:"), 10)
+	defer stop()
+
+	c.Set("user:1", 1, time.Second)
+	c.Set("user:1", 2, time.Second)
+	c.Set("other", 1, time.Hour)
+	c.Update("other", func(old interface{}) (interface{}, bool, time.Duration) { return nil, false, -1 })
+
+	clk.Advance(2 * time.Second)
+	c.Clean()
+
+	c.Set("user:2", 1, 0)
+	clk.Advance(time.Second)
+	c.Clean()
+
+	// Close delivers the queued events before closing the watchers.
+	c.Close()
+
+	var watched []string
+	for e := range ch {
+		watched = append(watched, e.Reason.String()+":"+e.Key)
+	}
+
+	if exp := "set:user:1 update:user:1 expire:user:1 set:user:2 expire:user:2"; strings.Join(watched, " ") != exp {
+		t.Fatalf("expected %q, got %q", exp, watched)
+	}
+
+	mux.Lock()
+	defer mux.Unlock()
+	if exp := "set:user:1 update:user:1 set:other delete:other expire:user:1 set:user:2 expire:user:2"; strings.Join(events, " ") != exp {
+		t.Fatalf("expected %q, got %q", exp, events)
+	}
+}
+
+func TestHooksQueue(t *testing.T) {
+	c := cache.New[int, int](cache.Options{MaxQueuedEvents: 2})
+
+	var (
+		got     = make(chan string, 20)
+		release = make(chan struct{})
+	)
+
+	c.OnSet(func(e cache.Event[int, int]) {
+		got <- e.Reason.String() + ":" + strconv.Itoa(e.Key)
+		<-release
+	})
+
+	// an unbuffered watcher drops everything, it's only used to know when all the events were delivered.
+	done, _ := c.Watch(nil, 0)
+
+	c.Set(0, 0, time.Minute)
+	if e := <-got; e != "set:0" {
+		t.Fatalf("expected set:0, got %s", e)
+	}
+
+	// the hook is blocked on 0, only 2 of these fit in the queue.
+	for i := 1; i <= 10; i++ {
+		c.Set(i, i, time.Minute)
+	}
+	close(release)
+
+	for i := 0; i < 500 && len(got) < 2; i++ {
+		time.Sleep(time.Millisecond)
+	}
+
+	// the next event after the dropped ones is preceded by an overflow marker.
+	c.Set(11, 11, time.Minute)
+	c.Close()
+	for range done {
+	}
+
+	var events []string
+	for len(got) > 0 {
+		events = append(events, <-got)
+	}
+
+	if exp := "set:1 set:2 overflow:0 set:11"; strings.Join(events, " ") != exp {
+		t.Fatalf("expected %q, got %q", exp, events)
+	}
+
+	if n := c.DroppedEvents(); n != 8 {
+		t.Fatalf("expected 8 dropped events, got %d", n)
+	}
+}
+
+func TestWatchOverflowReset(t *testing.T) {
+	c := cache.NewMemCache(0)
+	defer c.Close()
+
+	var (
+		mux     sync.Mutex
+		deletes []string
+	)
+
+	c.OnDelete(func(e cache.Event[string, interface{}]) {
+		mux.Lock()
+		deletes = append(deletes, e.Reason.String()+":"+e.Key)
+		mux.Unlock()
+	})
+
+	ch, stop := c.Watch(cache.Prefix("a"), 2)
+	defer stop()
+
+	// hooks run in order, so once this sees a key the watcher got it too.
+	seen := make(chan string, 10)
+	c.OnSet(func(e cache.Event[string, interface{}]) { seen <- e.Key })
+
+	next := func() string {
+		select {
+		case e := <-ch:
+			return e.Reason.String() + ":" + e.Key
+		case <-time.After(time.Second):
+			return "timeout"
+		}
+	}
+
+	// a3 doesn't fit in the channel.
+	c.Set("a1", 1, time.Minute)
+	c.Set("a2", 2, time.Minute)
+	c.Set("a3", 3, time.Minute)
+	for k := range seen {
+		if k == "a3" {
+			break
+		}
+	}
+
+	for _, exp := range []string{"set:a1", "set:a2"} {
+		if e := next(); e != exp {
+			t.Fatalf("expected %s, got %s", exp, e)
+		}
+	}
+
+	// resets go to every hook and watcher no matter what they asked for.
+	c.Reset()
+	for _, exp := range []string{"overflow:", "reset:"} {
+		if e := next(); e != exp {
+			t.Fatalf("expected %s, got %s", exp, e)
+		}
+	}
+
+	c.Delete("missing")
+	c.Set("b", 1, time.Minute)
+	c.Delete("b")
+	for i := 0; i < 500; i++ {
+		mux.Lock()
+		n := len(deletes)
+		mux.Unlock()
+		if n == 2 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	mux.Lock()
+	defer mux.Unlock()
+	if exp := "reset: delete:b"; strings.Join(deletes, " ") != exp {
+		t.Fatalf("expected %q, got %q", exp, deletes)
+	}
+}
@@ -0,0 +1,325 @@
+package cache
+
+import (
+	"container/heap"
+	"container/list"
+	"hash/maphash"
+)
+
+// EvictionPolicy picks which entries get evicted once a Cache is over Options.MaxEntries or Options.MaxBytes.
+type EvictionPolicy int
+
+const (
+	// EvictLRU evicts the least recently used entry.
+	EvictLRU EvictionPolicy = iota
+	// EvictLFU evicts the least frequently used entry, ties go to the least recently added or used.
+	EvictLFU
+	// EvictTinyLFU is W-TinyLFU: new entries go to a small LRU window,
+	// and only get into the main cache if they're used more often than the entry they'd replace.
+	EvictTinyLFU
+)
+
+// policy tracks keys for eviction, it's only called with Cache.pmux held.
+type policy[K comparable] interface {
+	add(k K)
+	touch(k K)
+	remove(k K)
+	// victim picks the next key to evict and stops tracking it.
+	victim() (k K, ok bool)
+	// reset stops tracking all the keys.
+	reset()
+}
+
+func newPolicy[K comparable](p EvictionPolicy, capacity int) policy[K] {
+	switch p {
+	case EvictLFU:
+		return &lfu[K]{m: map[K]*lfuItem[K]{}}
+	case EvictTinyLFU:
+		return newTinyLFU[K](capacity)
+	default:
+		return newLRU[K]()
+	}
+}
+
+type lru[K comparable] struct {
+	l list.List
+	m map[K]*list.Element
+}
+
+func newLRU[K comparable]() *lru[K] {
+	return &lru[K]{m: map[K]*list.Element{}}
+}
+
+func (p *lru[K]) len() int { return p.l.Len() }
+
+func (p *lru[K]) add(k K) {
+	if e := p.m[k]; e != nil {
+		p.l.MoveToFront(e)
+		return
+	}
+	p.m[k] = p.l.PushFront(k)
+}
+
+func (p *lru[K]) touch(k K) {
+	if e := p.m[k]; e != nil {
+		p.l.MoveToFront(e)
+	}
+}
+
+func (p *lru[K]) remove(k K) {
+	if e := p.m[k]; e != nil {
+		p.l.Remove(e)
+		delete(p.m, k)
+	}
+}
+
+func (p *lru[K]) has(k K) bool { return p.m[k] != nil }
+
+func (p *lru[K]) back() (k K, ok bool) {
+	if e := p.l.Back(); e != nil {
+		return e.Value.(K), true
+	}
+	return
+}
+
+func (p *lru[K]) victim() (k K, ok bool) {
+	if k, ok = p.back(); ok {
+		p.remove(k)
+	}
+	return
+}
+
+func (p *lru[K]) reset() {
+	p.l.Init()
+	p.m = map[K]*list.Element{}
+}
+
+type lfuItem[K comparable] struct {
+	key  K
+	freq uint64
+	seq  uint64
+	idx  int
+}
+
+// lfu is a min-heap ordered by (freq, seq).
+type lfu[K comparable] struct {
+	h   []*lfuItem[K]
+	m   map[K]*lfuItem[K]
+	seq uint64
+}
+
+func (p *lfu[K]) Len() int { return len(p.h) }
+func (p *lfu[K]) Less(i, j int) bool {
+	if a, b := p.h[i], p.h[j]; a.freq != b.freq {
+		return a.freq < b.freq
+	} else {
+		return a.seq < b.seq
+	}
+}
+func (p *lfu[K]) Swap(i, j int) {
+	p.h[i], p.h[j] = p.h[j], p.h[i]
+	p.h[i].idx, p.h[j].idx = i, j
+}
+func (p *lfu[K]) Push(x interface{}) {
+	it := x.(*lfuItem[K])
+	it.idx = len(p.h)
+	p.h = append(p.h, it)
+}
+func (p *lfu[K]) Pop() interface{} {
+	it := p.h[len(p.h)-1]
+	p.h[len(p.h)-1] = nil
+	p.h = p.h[:len(p.h)-1]
+	return it
+}
+
+func (p *lfu[K]) add(k K) {
+	if p.m[k] != nil {
+		p.touch(k)
+		return
+	}
+	p.seq++
+	it := &lfuItem[K]{key: k, freq: 1, seq: p.seq}
+	p.m[k] = it
+	heap.Push(p, it)
+}
+
+func (p *lfu[K]) touch(k K) {
+	if it := p.m[k]; it != nil {
+		p.seq++
+		it.freq++
+		it.seq = p.seq
+		heap.Fix(p, it.idx)
+	}
+}
+
+func (p *lfu[K]) remove(k K) {
+	if it := p.m[k]; it != nil {
+		heap.Remove(p, it.idx)
+		delete(p.m, k)
+	}
+}
+
+func (p *lfu[K]) victim() (k K, ok bool) {
+	if len(p.h) == 0 {
+		return
+	}
+	it := heap.Pop(p).(*lfuItem[K])
+	delete(p.m, it.key)
+	return it.key, true
+}
+
+func (p *lfu[K]) reset() {
+	p.h, p.m, p.seq = nil, map[K]*lfuItem[K]{}, 0
+}
+
+// tinyLFU is W-TinyLFU with a 1% LRU window and a segmented LRU main area,
+// 80% of which is reserved for entries that were used at least twice.
+type tinyLFU[K comparable] struct {
+	window    *lru[K]
+	probation *lru[K]
+	protected *lru[K]
+	sketch    *sketch[K]
+}
+
+func newTinyLFU[K comparable](capacity int) *tinyLFU[K] {
+	return &tinyLFU[K]{
+		window:    newLRU[K](),
+		probation: newLRU[K](),
+		protected: newLRU[K](),
+		sketch:    newSketch[K](capacity),
+	}
+}
+
+func (p *tinyLFU[K]) len() int { return p.window.len() + p.probation.len() + p.protected.len() }
+
+func (p *tinyLFU[K]) add(k K) {
+	if p.window.has(k) || p.probation.has(k) || p.protected.has(k) {
+		p.touch(k)
+		return
+	}
+	p.sketch.inc(k)
+	p.window.add(k)
+}
+
+func (p *tinyLFU[K]) touch(k K) {
+	p.sketch.inc(k)
+	switch {
+	case p.window.has(k):
+		p.window.touch(k)
+	case p.probation.has(k):
+		p.probation.remove(k)
+		p.protected.add(k)
+		// keep protected at 80% of main, demoting its oldest entries.
+		for main := p.probation.len() + p.protected.len(); p.protected.len() > main*8/10; {
+			d, _ := p.protected.victim()
+			p.probation.add(d)
+		}
+	case p.protected.has(k):
+		p.protected.touch(k)
+	}
+}
+
+func (p *tinyLFU[K]) remove(k K) {
+	p.window.remove(k)
+	p.probation.remove(k)
+	p.protected.remove(k)
+}
+
+func (p *tinyLFU[K]) victim() (k K, ok bool) {
+	// entries over the window's share move to the front of probation.
+	for p.window.len() > 1 && p.window.len() > p.len()/100 {
+		k, _ := p.window.victim()
+		p.probation.add(k)
+	}
+
+	if v, ok := p.probation.back(); ok {
+		// the newest entry only stays if it's used more often than the oldest one.
+		if cand := p.probation.l.Front().Value.(K); cand != v && p.sketch.estimate(cand) <= p.sketch.estimate(v) {
+			v = cand
+		}
+		p.probation.remove(v)
+		return v, true
+	}
+
+	if k, ok = p.protected.victim(); ok {
+		return
+	}
+
+	return p.window.victim()
+}
+
+func (p *tinyLFU[K]) reset() {
+	p.window.reset()
+	p.probation.reset()
+	p.protected.reset()
+	p.sketch.zero()
+}
+
+// sketch is a count-min sketch with 4 rows of 8 bit counters,
+// all counters are halved every 10 * width increments so old popularity fades.
+type sketch[K comparable] struct {
+	rows  [4][]uint8
+	mask  uint64
+	seed  maphash.Seed
+	incs  int
+	reset int
+}
+
+func newSketch[K comparable](capacity int) *sketch[K] {
+	width := 1024
+	for width < capacity {
+		width *= 2
+	}
+
+	s := &sketch[K]{mask: uint64(width - 1), seed: maphash.MakeSeed(), reset: width * 10}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, width)
+	}
+
+	return s
+}
+
+func (s *sketch[K]) zero() {
+	for i := range s.rows {
+		clear(s.rows[i])
+	}
+	s.incs = 0
+}
+
+func (s *sketch[K]) idx(h uint64, i int) uint64 {
+	// derive the row hashes from one 64 bit hash.
+	return (h + uint64(i)*(h>>32|1)) & s.mask
+}
+
+func (s *sketch[K]) inc(k K) {
+	h := maphash.Comparable(s.seed, k)
+	for i := range s.rows {
+		if c := &s.rows[i][s.idx(h, i)]; *c < 255 {
+			*c++
+		}
+	}
+
+	if s.incs++; s.incs >= s.reset {
+		s.incs = 0
+		for i := range s.rows {
+			for j := range s.rows[i] {
+				s.rows[i][j] /= 2
+			}
+		}
+	}
+}
+
+func (s *sketch[K]) estimate(k K) uint8 {
+	var (
+		h   = maphash.Comparable(s.seed, k)
+		min = uint8(255)
+	)
+
+	for i := range s.rows {
+		if c := s.rows[i][s.idx(h, i)]; c < min {
+			min = c
+		}
+	}
+
+	return min
+}
@@ -0,0 +1,25 @@
+package cache
+
+// expiryHeap is a min-heap of entries by ExpiresAt, so Clean only has to look at the expired ones.
+type expiryHeap[K comparable, V any] []*cacheItem[K, V]
+
+func (h expiryHeap[K, V]) Len() int           { return len(h) }
+func (h expiryHeap[K, V]) Less(i, j int) bool { return h[i].ExpiresAt < h[j].ExpiresAt }
+func (h expiryHeap[K, V]) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].hidx, h[j].hidx = i, j
+}
+
+func (h *expiryHeap[K, V]) Push(x interface{}) {
+	ci := x.(*cacheItem[K, V])
+	ci.hidx = len(*h)
+	*h = append(*h, ci)
+}
+
+func (h *expiryHeap[K, V]) Pop() interface{} {
+	old := *h
+	ci := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return ci
+}
@@ -0,0 +1,289 @@
+package cache
+
+import (
+	"strings"
+	"sync"
+)
+
+// Reason is why an Event happened.
+type Reason int
+
+const (
+	// ReasonSet is a new entry from Set, SetWithRefresh, Update or a GetOrLoad.
+	ReasonSet Reason = iota
+	// ReasonUpdate is an existing entry that got replaced, including by a background refresh.
+	ReasonUpdate
+	// ReasonDelete is an entry removed by Delete or by Update with a ttl of -1.
+	ReasonDelete
+	// ReasonExpire is an expired entry removed by Clean.
+	ReasonExpire
+	// ReasonEvict is an entry evicted to stay under Options.MaxEntries or Options.MaxBytes.
+	ReasonEvict
+	// ReasonReset means Reset removed all the entries, Key and Value are zero.
+	ReasonReset
+	// ReasonOverflow means events were dropped before this one, Key and Value are zero.
+	ReasonOverflow
+)
+
+func (r Reason) String() string {
+	switch r {
+	case ReasonSet:
+		return "set"
+	case ReasonUpdate:
+		return "update"
+	case ReasonDelete:
+		return "delete"
+	case ReasonExpire:
+		return "expire"
+	case ReasonEvict:
+		return "evict"
+	case ReasonReset:
+		return "reset"
+	case ReasonOverflow:
+		return "overflow"
+	default:
+		return "unknown"
+	}
+}
+
+// Event is passed to hooks and watchers.
+type Event[K comparable, V any] struct {
+	Reason Reason
+	Key    K
+	// Value is the new value for ReasonSet and ReasonUpdate, and the removed value otherwise.
+	Value V
+	// Old is the replaced value for ReasonUpdate.
+	Old V
+}
+
+// Prefix returns a Watch matcher for string keys that start with prefix.
+func Prefix(prefix string) func(key string) bool {
+	return func(key string) bool { return strings.HasPrefix(key, prefix) }
+}
+
+// OnSet adds a hook for ReasonSet and ReasonUpdate events, remove removes it.
+// Hooks are called in order from a single background goroutine, so they never run under the cache's locks,
+// but a slow hook delays the events of all the others, and events are dropped once Options.MaxQueuedEvents are waiting.
+// Every hook and watcher also gets ReasonReset events, and a ReasonOverflow event before the first event
+// that follows dropped ones, so anything derived from the cache can be invalidated instead of going stale.
+func (c *Cache[K, V]) OnSet(fn func(e Event[K, V])) (remove func()) {
+	return c.hooks.add(nil, fn, ReasonSet, ReasonUpdate)
+}
+
+// OnDelete adds a hook for ReasonDelete events, see OnSet.
+func (c *Cache[K, V]) OnDelete(fn func(e Event[K, V])) (remove func()) {
+	return c.hooks.add(nil, fn, ReasonDelete)
+}
+
+// OnExpire adds a hook for ReasonExpire events, see OnSet.
+func (c *Cache[K, V]) OnExpire(fn func(e Event[K, V])) (remove func()) {
+	return c.hooks.add(nil, fn, ReasonExpire)
+}
+
+// OnEvictEvent adds a hook for ReasonEvict events, see OnSet.
+func (c *Cache[K, V]) OnEvictEvent(fn func(e Event[K, V])) (remove func()) {
+	return c.hooks.add(nil, fn, ReasonEvict)
+}
+
+// Watch returns a channel that gets all the events for keys that match, or all keys if match is nil.
+// Events are dropped if the channel's buffer is full, then a ReasonOverflow event is sent once there's room again.
+// The channel is closed by stop or Close.
+func (c *Cache[K, V]) Watch(match func(key K) bool, buffer int) (events <-chan Event[K, V], stop func()) {
+	w := newWatcher[K, V](buffer)
+	remove := c.hooks.add(match, w.send, allReasons...)
+	c.hooks.atClose(w.close)
+	return w.ch, func() {
+		remove()
+		w.close()
+	}
+}
+
+// DroppedEvents returns the number of events that hooks and watchers missed because Options.MaxQueuedEvents were waiting,
+// it doesn't count the events dropped by full Watch channels.
+func (c *Cache[K, V]) DroppedEvents() uint64 {
+	c.hooks.mux.Lock()
+	defer c.hooks.mux.Unlock()
+	return c.hooks.dropped
+}
+
+var allReasons = []Reason{ReasonSet, ReasonUpdate, ReasonDelete, ReasonExpire, ReasonEvict}
+
+type watcher[K comparable, V any] struct {
+	mux      sync.Mutex
+	ch       chan Event[K, V]
+	closed   bool
+	overflow bool
+}
+
+func newWatcher[K comparable, V any](buffer int) *watcher[K, V] {
+	return &watcher[K, V]{ch: make(chan Event[K, V], buffer)}
+}
+
+func (w *watcher[K, V]) send(e Event[K, V]) {
+	w.mux.Lock()
+	if !w.closed {
+		if w.overflow {
+			select {
+			case w.ch <- Event[K, V]{Reason: ReasonOverflow}:
+				w.overflow = false
+			default:
+			}
+		}
+
+		if !w.overflow {
+			select {
+			case w.ch <- e:
+			default:
+				w.overflow = true
+			}
+		}
+	}
+	w.mux.Unlock()
+}
+
+func (w *watcher[K, V]) close() {
+	w.mux.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.ch)
+	}
+	w.mux.Unlock()
+}
+
+type hook[K comparable, V any] struct {
+	reasons uint
+	match   func(key K) bool
+	fn      func(e Event[K, V])
+}
+
+// wants returns true for the events hk asked for, and for the ones about the whole cache.
+func (hk *hook[K, V]) wants(e Event[K, V]) bool {
+	if e.Reason == ReasonReset || e.Reason == ReasonOverflow {
+		return true
+	}
+	return hk.reasons&(1<<uint(e.Reason)) != 0 && (hk.match == nil || hk.match(e.Key))
+}
+
+// hooks queues events and delivers them from a goroutine that's started by the first hook.
+type hooks[K comparable, V any] struct {
+	mux      sync.Mutex
+	cond     sync.Cond
+	order    []*hook[K, V]
+	queue    []Event[K, V]
+	max      int
+	dropped  uint64
+	overflow bool
+	started  bool
+	closed   bool
+	onClose  []func()
+}
+
+func (h *hooks[K, V]) add(match func(key K) bool, fn func(e Event[K, V]), reasons ...Reason) (remove func()) {
+	hk := &hook[K, V]{match: match, fn: fn}
+	for _, r := range reasons {
+		hk.reasons |= 1 << uint(r)
+	}
+
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	if h.closed {
+		return func() {}
+	}
+
+	h.order = append(h.order, hk)
+
+	if !h.started {
+		h.started = true
+		h.cond.L = &h.mux
+		go h.run()
+	}
+
+	return func() {
+		h.mux.Lock()
+		for i, o := range h.order {
+			if o == hk {
+				h.order = append(h.order[:i:i], h.order[i+1:]...)
+				break
+			}
+		}
+		h.mux.Unlock()
+	}
+}
+
+// atClose calls fn once all the events are delivered after close, or right away if it was already called.
+func (h *hooks[K, V]) atClose(fn func()) {
+	h.mux.Lock()
+	if !h.closed {
+		h.onClose = append(h.onClose, fn)
+		fn = nil
+	}
+	h.mux.Unlock()
+
+	if fn != nil {
+		fn()
+	}
+}
+
+// emit is called with the cache's mux held, it only queues the event, or drops it if the queue is full.
+func (h *hooks[K, V]) emit(e Event[K, V]) {
+	h.mux.Lock()
+	switch {
+	case len(h.order) == 0 || h.closed:
+	case len(h.queue) >= h.max:
+		h.dropped++
+		h.overflow = true
+	default:
+		if h.overflow {
+			h.overflow = false
+			h.queue = append(h.queue, Event[K, V]{Reason: ReasonOverflow})
+		}
+		h.queue = append(h.queue, e)
+		h.cond.Signal()
+	}
+	h.mux.Unlock()
+}
+
+func (h *hooks[K, V]) run() {
+	h.mux.Lock()
+	for {
+		for len(h.queue) == 0 && !h.closed {
+			h.cond.Wait()
+		}
+
+		if len(h.queue) == 0 {
+			break
+		}
+
+		q, order := h.queue, h.order
+		h.queue = nil
+		h.mux.Unlock()
+
+		for _, e := range q {
+			for _, hk := range order {
+				if hk.wants(e) {
+					hk.fn(e)
+				}
+			}
+		}
+
+		h.mux.Lock()
+	}
+	fns := h.onClose
+	h.onClose = nil
+	h.mux.Unlock()
+
+	for _, fn := range fns {
+		fn()
+	}
+}
+
+// close delivers the queued events and stops the goroutine.
+func (h *hooks[K, V]) close() {
+	h.mux.Lock()
+	h.closed = true
+	if h.started {
+		h.cond.Signal()
+	}
+	h.mux.Unlock()
+}
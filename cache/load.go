@@ -0,0 +1,142 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/PathDNA/ptk"
+)
+
+type loadCall[V any] struct {
+	done chan struct{}
+	val  V
+	err  error
+}
+
+// GetOrLoad returns the cached value of key, or calls loader and caches its value for ttl.
+// Concurrent calls for the same key share a single loader call and all get its error if it fails,
+// failures are cached for Options.NegativeTTL if it's set.
+// loader keeps running if ctx is done, so its value is still cached for the other callers,
+// it doesn't block other calls on key, and a value set while it runs is kept over the loaded one.
+// If loader panics, the callers get a *ptk.PanicError.
+func (c *Cache[K, V]) GetOrLoad(ctx context.Context, key K, loader func(ctx context.Context) (V, error), ttl time.Duration) (V, error) {
+	return c.getOrLoad(ctx, key, loader, func(val V) *cacheItem[K, V] {
+		return &cacheItem[K, V]{Value: val, ExpiresAt: c.clock.Now().Add(ttl).UnixNano()}
+	})
+}
+
+// GetOrLoadWithRefresh is like GetOrLoad, but the loaded value is cached like SetWithRefresh with loader as refresh.
+func (c *Cache[K, V]) GetOrLoadWithRefresh(ctx context.Context, key K, loader func(ctx context.Context) (V, error), softTTL, hardTTL time.Duration) (V, error) {
+	return c.getOrLoad(ctx, key, loader, func(val V) *cacheItem[K, V] {
+		return c.newRefreshItem(val, softTTL, hardTTL, loader)
+	})
+}
+
+func (c *Cache[K, V]) getOrLoad(ctx context.Context, key K, loader func(ctx context.Context) (V, error), newItem func(val V) *cacheItem[K, V]) (V, error) {
+	if val, ok, err := c.getLoaded(key); ok {
+		return val, err
+	}
+
+	c.lmux.Lock()
+	call := c.loads[key]
+	if call == nil {
+		if c.loads == nil {
+			c.loads = map[K]*loadCall[V]{}
+		}
+		call = &loadCall[V]{done: make(chan struct{})}
+		c.loads[key] = call
+		go c.load(context.WithoutCancel(ctx), key, call, loader, newItem)
+	}
+	c.lmux.Unlock()
+
+	select {
+	case <-call.done:
+		return call.val, call.err
+	case <-ctx.Done():
+		var zero V
+		return zero, ctx.Err()
+	}
+}
+
+func (c *Cache[K, V]) load(ctx context.Context, key K, call *loadCall[V], loader func(ctx context.Context) (V, error), newItem func(val V) *cacheItem[K, V]) {
+	defer func() {
+		c.lmux.Lock()
+		delete(c.loads, key)
+		c.lmux.Unlock()
+		close(call.done)
+	}()
+
+	// someone might've set it since getOrLoad checked.
+	var loaded bool
+	if call.val, loaded, call.err = c.getLoaded(key); loaded {
+		return
+	}
+
+	// c.loads already dedupes loads, so loader doesn't hold the key's lock and can't block Get, Set or Delete.
+	call.val, call.err = callLoader(ctx, loader)
+
+	if call.err != nil && c.negativeTTL <= 0 {
+		return
+	}
+
+	var ev []evicted[K, V]
+	c.mmux.Update(key, func() {
+		// a value set while we were loading is newer than ours.
+		if val, ok, err := c.getLoaded(key); ok {
+			call.val, call.err = val, err
+			return
+		}
+
+		var ci *cacheItem[K, V]
+		if call.err != nil {
+			ci = &cacheItem[K, V]{err: call.err, ExpiresAt: c.clock.Now().Add(c.negativeTTL).UnixNano()}
+		} else {
+			ci = newItem(call.val)
+		}
+
+		c.mux.Lock()
+		if c.c != nil {
+			ev = c.store(key, ci)
+		}
+		c.mux.Unlock()
+	})
+
+	c.notifyEvicted(ev)
+}
+
+// callLoader calls loader, converting panics to *ptk.PanicError.
+func callLoader[V any](ctx context.Context, loader func(ctx context.Context) (V, error)) (val V, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = ptk.NewPanicError(v)
+		}
+	}()
+
+	return loader(ctx)
+}
+
+// getLoaded returns the value or cached error of key if it exists and didn't expire.
+func (c *Cache[K, V]) getLoaded(key K) (val V, ok bool, err error) {
+	now := c.clock.Now().UnixNano()
+
+	c.mux.RLock()
+	ci := c.c[key]
+	if ci != nil && now <= ci.ExpiresAt {
+		val, ok, err = ci.Value, true, ci.err
+	}
+	c.mux.RUnlock()
+
+	if !ok {
+		return
+	}
+
+	if c.policy != nil {
+		c.pmux.Lock()
+		c.policy.touch(key)
+		c.pmux.Unlock()
+	}
+
+	c.maybeRefresh(key, ci, now)
+
+	return
+}
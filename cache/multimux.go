@@ -0,0 +1,53 @@
+package cache
+
+import "sync"
+
+// multiMux is a per-key RWMutex, like atoms.MultiMux but for any comparable key.
+// Locks are ref counted and removed once nothing holds or waits on them.
+type multiMux[K comparable] struct {
+	mux sync.Mutex
+	m   map[K]*keyLock
+}
+
+type keyLock struct {
+	sync.RWMutex
+	refs int
+}
+
+func (mm *multiMux[K]) Update(key K, fn func()) {
+	l := mm.acquire(key)
+	l.Lock()
+	defer mm.release(key, l, l.Unlock)
+	fn()
+}
+
+func (mm *multiMux[K]) Read(key K, fn func()) {
+	l := mm.acquire(key)
+	l.RLock()
+	defer mm.release(key, l, l.RUnlock)
+	fn()
+}
+
+func (mm *multiMux[K]) acquire(key K) *keyLock {
+	mm.mux.Lock()
+	if mm.m == nil {
+		mm.m = map[K]*keyLock{}
+	}
+	l := mm.m[key]
+	if l == nil {
+		l = &keyLock{}
+		mm.m[key] = l
+	}
+	l.refs++
+	mm.mux.Unlock()
+	return l
+}
+
+func (mm *multiMux[K]) release(key K, l *keyLock, unlock func()) {
+	unlock()
+	mm.mux.Lock()
+	if l.refs--; l.refs == 0 {
+		delete(mm.m, key)
+	}
+	mm.mux.Unlock()
+}
@@ -0,0 +1,60 @@
+package cache
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMultiMuxRelease(t *testing.T) {
+	var (
+		mm     multiMux[int]
+		counts [4]int
+		wg     sync.WaitGroup
+	)
+
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				k := (g + i) % len(counts)
+				if i%3 == 0 {
+					mm.Read(k, func() { _ = counts[k] })
+					continue
+				}
+				mm.Update(k, func() { counts[k]++ })
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	if n := len(mm.m); n != 0 {
+		t.Fatalf("expected all the key locks to be released, %d left", n)
+	}
+
+	c := New[string, int](Options{})
+	defer c.Close()
+
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				k := strconv.Itoa(i % 10)
+				c.Set(k, i, time.Minute)
+				c.Get(k)
+				c.Update(k, func(old int) (int, bool, time.Duration) { return old + 1, true, 0 })
+				if i%7 == 0 {
+					c.Delete(k)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	if n := len(c.mmux.m); n != 0 {
+		t.Fatalf("expected all the cache's key locks to be released, %d left", n)
+	}
+}
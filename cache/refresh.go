@@ -0,0 +1,78 @@
+package cache
+
+import (
+	"context"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// SetWithRefresh sets key to val like Set with hardTTL, but once softTTL passes,
+// Get keeps returning val while refresh runs in the background, on Options.RefreshLimiter, to replace it.
+// If refresh fails, the stale value is kept and the next Get tries again.
+func (c *Cache[K, V]) SetWithRefresh(key K, val V, softTTL, hardTTL time.Duration, refresh func(ctx context.Context) (V, error)) (err error) {
+	var ev []evicted[K, V]
+	c.mmux.Update(key, func() {
+		c.mux.Lock()
+		if c.c == nil {
+			err = os.ErrClosed
+		} else {
+			ev = c.store(key, c.newRefreshItem(val, softTTL, hardTTL, refresh))
+		}
+		c.mux.Unlock()
+	})
+	c.notifyEvicted(ev)
+
+	return
+}
+
+func (c *Cache[K, V]) newRefreshItem(val V, softTTL, hardTTL time.Duration, refresh func(ctx context.Context) (V, error)) *cacheItem[K, V] {
+	now := c.clock.Now()
+	return &cacheItem[K, V]{
+		Value:     val,
+		ExpiresAt: now.Add(hardTTL).UnixNano(),
+		staleAt:   now.Add(softTTL).UnixNano(),
+		softTTL:   softTTL,
+		hardTTL:   hardTTL,
+		refresh:   refresh,
+	}
+}
+
+// maybeRefresh starts a background refresh of ci if it's stale and isn't being refreshed already.
+func (c *Cache[K, V]) maybeRefresh(key K, ci *cacheItem[K, V], now int64) {
+	if ci.refresh == nil || now <= ci.staleAt || !atomic.CompareAndSwapInt32(&ci.refreshing, 0, 1) {
+		return
+	}
+
+	errCh := c.refresher.TryAdd(func(ctx context.Context) error {
+		// clear the flag even if refresh panics so the next Get can try again, ci is unused once it was replaced.
+		defer atomic.StoreInt32(&ci.refreshing, 0)
+
+		val, err := ci.refresh(ctx)
+		if err != nil {
+			return err
+		}
+
+		var ev []evicted[K, V]
+		c.mmux.Update(key, func() {
+			c.mux.Lock()
+			// it might've been set or deleted while we were refreshing.
+			if c.c != nil && c.c[key] == ci {
+				ev = c.store(key, c.newRefreshItem(val, ci.softTTL, ci.hardTTL, ci.refresh))
+			}
+			c.mux.Unlock()
+		})
+		c.notifyEvicted(ev)
+
+		return nil
+	})
+
+	// TryAdd fails right away if the limiter is busy, let the next Get try again.
+	select {
+	case err := <-errCh:
+		if err != nil {
+			atomic.StoreInt32(&ci.refreshing, 0)
+		}
+	default:
+	}
+}
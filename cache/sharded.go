@@ -0,0 +1,241 @@
+package cache
+
+import (
+	"context"
+	"hash/maphash"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	"github.com/PathDNA/ptk"
+	"github.com/PathDNA/ptk/bglimiter"
+)
+
+// NewShardedMemCache is an alias for NewSharded[string, interface{}](shards, opts).
+func NewShardedMemCache(shards int, opts Options) *Sharded[string, interface{}] {
+	return NewSharded[string, interface{}](shards, opts)
+}
+
+// NewSharded returns a cache split into shards independent Caches, so operations on different keys rarely share a lock.
+// If shards <= 0, it defaults to 4 * GOMAXPROCS, it's always rounded up to a power of 2,
+// then halved until every shard gets at least 1 of Options.MaxEntries and Options.MaxBytes.
+// The limits are split evenly between the shards, rounding down, so the whole cache never goes over them,
+// but a shard can evict entries a bit before the whole cache reaches them.
+func NewSharded[K comparable, V any](shards int, opts Options) *Sharded[K, V] {
+	if shards <= 0 {
+		shards = 4 * runtime.GOMAXPROCS(0)
+	}
+
+	n := 1
+	for n < shards {
+		n *= 2
+	}
+
+	for n > 1 && ((opts.MaxEntries > 0 && n > opts.MaxEntries) || (opts.MaxBytes > 0 && int64(n) > opts.MaxBytes)) {
+		n /= 2
+	}
+
+	s := &Sharded[K, V]{
+		shards: make([]*Cache[K, V], n),
+		mask:   uint64(n - 1),
+		seed:   maphash.MakeSeed(),
+		done:   make(chan struct{}),
+	}
+
+	if opts.RefreshLimiter == nil {
+		opts.RefreshLimiter = bglimiter.NewWithContext(context.Background(), 4)
+		s.refresher = opts.RefreshLimiter
+	}
+
+	if opts.MaxEntries > 0 {
+		opts.MaxEntries /= n
+	}
+
+	if opts.MaxBytes > 0 {
+		opts.MaxBytes /= int64(n)
+	}
+
+	// one sweeper for all the shards
+	cleanEvery := opts.AutoCleanEvery
+	opts.AutoCleanEvery = 0
+
+	for i := range s.shards {
+		s.shards[i] = New[K, V](opts)
+	}
+
+	if cleanEvery > 0 {
+		t := ptk.ClockOrReal(opts.Clock).NewTicker(cleanEvery)
+		go func() {
+			defer t.Stop()
+			for {
+				select {
+				case <-s.done:
+					return
+				case <-t.C():
+					s.Clean()
+				}
+			}
+		}()
+	}
+
+	return s
+}
+
+// Sharded is a Cache split into shards by key hash, it has the same API as Cache.
+type Sharded[K comparable, V any] struct {
+	shards    []*Cache[K, V]
+	mask      uint64
+	seed      maphash.Seed
+	done      chan struct{}
+	refresher *bglimiter.BackgroundLimiter // only set if we own it
+}
+
+func (s *Sharded[K, V]) shard(key K) *Cache[K, V] {
+	return s.shards[maphash.Comparable(s.seed, key)&s.mask]
+}
+
+func (s *Sharded[K, V]) SetSizer(fn func(key K, val V) int64) {
+	for _, c := range s.shards {
+		c.SetSizer(fn)
+	}
+}
+
+func (s *Sharded[K, V]) OnEvict(fn func(key K, val V)) {
+	for _, c := range s.shards {
+		c.OnEvict(fn)
+	}
+}
+
+// OnSet is like Cache.OnSet, but every shard calls fn from its own goroutine, so it can be called concurrently.
+func (s *Sharded[K, V]) OnSet(fn func(e Event[K, V])) (remove func()) {
+	return s.each(func(c *Cache[K, V]) func() { return c.OnSet(fn) })
+}
+
+func (s *Sharded[K, V]) OnDelete(fn func(e Event[K, V])) (remove func()) {
+	return s.each(func(c *Cache[K, V]) func() { return c.OnDelete(fn) })
+}
+
+func (s *Sharded[K, V]) OnExpire(fn func(e Event[K, V])) (remove func()) {
+	return s.each(func(c *Cache[K, V]) func() { return c.OnExpire(fn) })
+}
+
+func (s *Sharded[K, V]) OnEvictEvent(fn func(e Event[K, V])) (remove func()) {
+	return s.each(func(c *Cache[K, V]) func() { return c.OnEvictEvent(fn) })
+}
+
+// Watch is like Cache.Watch, but events are only ordered per key since every shard delivers its own.
+func (s *Sharded[K, V]) Watch(match func(key K) bool, buffer int) (events <-chan Event[K, V], stop func()) {
+	var (
+		w    = newWatcher[K, V](buffer)
+		open = int32(len(s.shards))
+	)
+
+	remove := s.each(func(c *Cache[K, V]) func() {
+		rm := c.hooks.add(match, w.send, allReasons...)
+		// close the channel once all the shards are closed.
+		c.hooks.atClose(func() {
+			if atomic.AddInt32(&open, -1) == 0 {
+				w.close()
+			}
+		})
+		return rm
+	})
+
+	return w.ch, func() {
+		remove()
+		w.close()
+	}
+}
+
+func (s *Sharded[K, V]) each(fn func(c *Cache[K, V]) func()) func() {
+	removes := make([]func(), len(s.shards))
+	for i, c := range s.shards {
+		removes[i] = fn(c)
+	}
+
+	return func() {
+		for _, rm := range removes {
+			rm()
+		}
+	}
+}
+
+func (s *Sharded[K, V]) Set(key K, val V, ttl time.Duration) error {
+	return s.shard(key).Set(key, val, ttl)
+}
+
+func (s *Sharded[K, V]) SetWithRefresh(key K, val V, softTTL, hardTTL time.Duration, refresh func(ctx context.Context) (V, error)) error {
+	return s.shard(key).SetWithRefresh(key, val, softTTL, hardTTL, refresh)
+}
+
+func (s *Sharded[K, V]) Update(key K, fn func(old V) (val V, keepOldTTL bool, ttl time.Duration)) error {
+	return s.shard(key).Update(key, fn)
+}
+
+func (s *Sharded[K, V]) Delete(key K) error {
+	return s.shard(key).Delete(key)
+}
+
+func (s *Sharded[K, V]) Get(key K) (V, bool) {
+	return s.shard(key).Get(key)
+}
+
+func (s *Sharded[K, V]) GetOrLoad(ctx context.Context, key K, loader func(ctx context.Context) (V, error), ttl time.Duration) (V, error) {
+	return s.shard(key).GetOrLoad(ctx, key, loader, ttl)
+}
+
+func (s *Sharded[K, V]) GetOrLoadWithRefresh(ctx context.Context, key K, loader func(ctx context.Context) (V, error), softTTL, hardTTL time.Duration) (V, error) {
+	return s.shard(key).GetOrLoadWithRefresh(ctx, key, loader, softTTL, hardTTL)
+}
+
+func (s *Sharded[K, V]) Clean() (n int) {
+	for _, c := range s.shards {
+		n += c.Clean()
+	}
+	return
+}
+
+// Reset resets every shard, so hooks and watchers get one ReasonReset event per shard.
+func (s *Sharded[K, V]) Reset() {
+	for _, c := range s.shards {
+		c.Reset()
+	}
+}
+
+func (s *Sharded[K, V]) Len() (n int) {
+	for _, c := range s.shards {
+		n += c.Len()
+	}
+	return
+}
+
+func (s *Sharded[K, V]) Bytes() (n int64) {
+	for _, c := range s.shards {
+		n += c.Bytes()
+	}
+	return
+}
+
+func (s *Sharded[K, V]) DroppedEvents() (n uint64) {
+	for _, c := range s.shards {
+		n += c.DroppedEvents()
+	}
+	return
+}
+
+func (s *Sharded[K, V]) Close() (err error) {
+	for _, c := range s.shards {
+		if cerr := c.Close(); cerr != nil {
+			err = cerr
+		}
+	}
+
+	if err == nil {
+		close(s.done)
+		if s.refresher != nil {
+			s.refresher.Close()
+		}
+	}
+
+	return
+}
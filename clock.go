@@ -0,0 +1,212 @@
+package ptk
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock abstracts the time package so time based helpers can be tested without sleeping.
+type Clock interface {
+	Now() time.Time
+	Since(t time.Time) time.Duration
+	After(d time.Duration) <-chan time.Time
+	Sleep(d time.Duration)
+	NewTimer(d time.Duration) Timer
+	NewTicker(d time.Duration) Ticker
+	AfterFunc(d time.Duration, fn func()) Timer
+}
+
+// Timer is the Clock version of *time.Timer.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// Ticker is the Clock version of *time.Ticker.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// RealClock is a Clock backed by the time package.
+var RealClock Clock = realClock{}
+
+// ClockOrReal returns c, or RealClock if c is nil.
+func ClockOrReal(c Clock) Clock {
+	if c == nil {
+		return RealClock
+	}
+	return c
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) Since(t time.Time) time.Duration        { return time.Since(t) }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (realClock) Sleep(d time.Duration)                  { time.Sleep(d) }
+func (realClock) NewTimer(d time.Duration) Timer         { return realTimer{time.NewTimer(d)} }
+func (realClock) NewTicker(d time.Duration) Ticker       { return realTicker{time.NewTicker(d)} }
+func (realClock) AfterFunc(d time.Duration, fn func()) Timer {
+	return realTimer{time.AfterFunc(d, fn)}
+}
+
+type realTimer struct{ *time.Timer }
+
+func (t realTimer) C() <-chan time.Time { return t.Timer.C }
+
+type realTicker struct{ *time.Ticker }
+
+func (t realTicker) C() <-chan time.Time { return t.Ticker.C }
+
+// NewFakeClock returns a FakeClock set to now, if now is zero, it uses time.Now().
+func NewFakeClock(now time.Time) *FakeClock {
+	if now.IsZero() {
+		now = time.Now()
+	}
+	return &FakeClock{now: now}
+}
+
+// FakeClock is a Clock that only moves when Advance or Set are called.
+// Timers and tickers fire synchronously from Advance/Set, AfterFunc funcs are called on the same goroutine.
+type FakeClock struct {
+	mux    sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+func (fc *FakeClock) Now() time.Time {
+	fc.mux.Lock()
+	defer fc.mux.Unlock()
+	return fc.now
+}
+
+func (fc *FakeClock) Since(t time.Time) time.Duration { return fc.Now().Sub(t) }
+
+func (fc *FakeClock) After(d time.Duration) <-chan time.Time { return fc.NewTimer(d).C() }
+
+func (fc *FakeClock) Sleep(d time.Duration) { <-fc.After(d) }
+
+func (fc *FakeClock) NewTimer(d time.Duration) Timer {
+	return fc.add(&fakeTimer{fc: fc, ch: make(chan time.Time, 1)}, d)
+}
+
+func (fc *FakeClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+	return fakeTicker{fc.add(&fakeTimer{fc: fc, ch: make(chan time.Time, 1), period: d}, d)}
+}
+
+func (fc *FakeClock) AfterFunc(d time.Duration, fn func()) Timer {
+	return fc.add(&fakeTimer{fc: fc, fn: fn}, d)
+}
+
+// Advance moves the clock forward by d, firing any timers that expire on the way.
+func (fc *FakeClock) Advance(d time.Duration) {
+	fc.Set(fc.Now().Add(d))
+}
+
+// Set sets the clock to t, firing any timers that expire on the way, in order.
+// Set doesn't move the clock backwards.
+func (fc *FakeClock) Set(t time.Time) {
+	for {
+		fc.mux.Lock()
+		if len(fc.timers) == 0 || fc.timers[0].when.After(t) {
+			if t.After(fc.now) {
+				fc.now = t
+			}
+			fc.mux.Unlock()
+			return
+		}
+
+		ft := fc.timers[0]
+		if ft.when.After(fc.now) {
+			fc.now = ft.when
+		}
+
+		if ft.period > 0 {
+			ft.when = ft.when.Add(ft.period)
+			fc.sort()
+		} else {
+			fc.remove(ft)
+		}
+		now := fc.now
+		fc.mux.Unlock()
+
+		if ft.fn != nil {
+			ft.fn()
+		} else {
+			select {
+			case ft.ch <- now:
+			default:
+			}
+		}
+	}
+}
+
+// Timers returns the number of active timers and tickers, useful to know when a goroutine is waiting on the clock.
+func (fc *FakeClock) Timers() int {
+	fc.mux.Lock()
+	defer fc.mux.Unlock()
+	return len(fc.timers)
+}
+
+func (fc *FakeClock) add(ft *fakeTimer, d time.Duration) *fakeTimer {
+	fc.mux.Lock()
+	ft.when = fc.now.Add(d)
+	fc.timers = append(fc.timers, ft)
+	fc.sort()
+	fc.mux.Unlock()
+
+	if d <= 0 {
+		fc.Set(fc.Now())
+	}
+
+	return ft
+}
+
+func (fc *FakeClock) remove(ft *fakeTimer) (found bool) {
+	for i, t := range fc.timers {
+		if t == ft {
+			fc.timers = append(fc.timers[:i], fc.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (fc *FakeClock) sort() {
+	sort.SliceStable(fc.timers, func(i, j int) bool { return fc.timers[i].when.Before(fc.timers[j].when) })
+}
+
+type fakeTimer struct {
+	fc     *FakeClock
+	when   time.Time
+	period time.Duration
+	ch     chan time.Time
+	fn     func()
+}
+
+func (ft *fakeTimer) C() <-chan time.Time { return ft.ch }
+
+func (ft *fakeTimer) Stop() bool {
+	ft.fc.mux.Lock()
+	defer ft.fc.mux.Unlock()
+	return ft.fc.remove(ft)
+}
+
+func (ft *fakeTimer) Reset(d time.Duration) bool {
+	ft.fc.mux.Lock()
+	active := ft.fc.remove(ft)
+	ft.fc.mux.Unlock()
+
+	ft.fc.add(ft, d)
+	return active
+}
+
+type fakeTicker struct{ *fakeTimer }
+
+func (ft fakeTicker) Stop() { ft.fakeTimer.Stop() }
@@ -0,0 +1,85 @@
+package ptk_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/PathDNA/ptk"
+)
+
+func TestFakeClock(t *testing.T) {
+	clk := ptk.NewFakeClock(time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	ts := ptk.NewTimeoutSetWithClock(time.Hour, clk)
+
+	ts.Set("a", time.Minute)
+	if !ts.Has("a") {
+		t.Fatal("expected a")
+	}
+
+	clk.Advance(time.Minute)
+	if ts.Has("a") {
+		t.Fatal("a should've expired")
+	}
+
+	var delays []time.Duration
+	errCh := make(chan ptk.RetryResult, 1)
+	go func() {
+		errCh <- ptk.RetryWithOptions(context.Background(), func(ctx context.Context) error {
+			if n, _, _ := ptk.RetryAttempt(ctx); n < 3 {
+				return errors.New("not yet")
+			}
+			return nil
+		}, ptk.RetryOptions{
+			Attempts:   5,
+			Delay:      time.Second,
+			BackoffMod: 2,
+			Clock:      clk,
+			OnRetry:    func(_ uint, _ error, d time.Duration) { delays = append(delays, d) },
+		})
+	}()
+
+	for i := 0; i < 2; i++ {
+		for clk.Timers() < 2 { // the TimeoutSet purger + the retry timer
+			time.Sleep(time.Millisecond)
+		}
+		clk.Advance(time.Duration(i+1) * time.Second)
+	}
+
+	res := <-errCh
+	if res.Err != nil || res.Attempts != 3 || res.Elapsed != 3*time.Second {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+
+	if len(delays) != 2 || delays[0] != time.Second || delays[1] != 2*time.Second {
+		t.Fatalf("unexpected delays: %v", delays)
+	}
+
+	// the purger should stop its ticker on Close.
+	ts.Close()
+	for i := 0; clk.Timers() > 0; i++ {
+		if i == 1000 {
+			t.Fatal("the purge ticker wasn't stopped")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestTimeoutSetNoPurge(t *testing.T) {
+	clk := ptk.NewFakeClock(time.Time{})
+	ts := ptk.NewTimeoutSetWithClock(0, clk)
+
+	ts.Set("a", time.Minute)
+	clk.Advance(time.Minute)
+	if ts.Has("a") || len(ts.Keys()) != 0 || ts.Len() != 1 {
+		t.Fatal("a should've expired without being purged")
+	}
+
+	if err := ts.Close(); err != nil || clk.Timers() != 0 {
+		t.Fatalf("unexpected Close: %v, %d timers", err, clk.Timers())
+	}
+
+	ptk.NewTimeoutSet(0).Close()
+}
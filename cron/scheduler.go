@@ -0,0 +1,197 @@
+package cron
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/PathDNA/ptk"
+	"github.com/PathDNA/ptk/bglimiter"
+)
+
+// New returns a new Scheduler that runs jobs using bg, and parses specs in loc.
+// If bg is nil, bglimiter.New() is used, if loc is nil, time.UTC is used.
+// The scheduler stops when bg is closed.
+func New(bg *bglimiter.BackgroundLimiter, loc *time.Location) *Scheduler {
+	return NewWithClock(bg, loc, nil)
+}
+
+// NewWithClock is like New, but uses clk to keep time, if clk is nil, ptk.RealClock is used.
+func NewWithClock(bg *bglimiter.BackgroundLimiter, loc *time.Location, clk ptk.Clock) *Scheduler {
+	if bg == nil {
+		bg = bglimiter.New()
+	}
+
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	s := &Scheduler{
+		bg:   bg,
+		loc:  loc,
+		clk:  ptk.ClockOrReal(clk),
+		jobs: map[string]*job{},
+		busy: map[string]bool{},
+		wake: make(chan struct{}, 1),
+	}
+
+	go s.loop()
+
+	return s
+}
+
+// Scheduler runs jobs on their schedules, a job never overlaps with itself,
+// if it's still running when it's due again, that activation is skipped.
+type Scheduler struct {
+	bg  *bglimiter.BackgroundLimiter
+	loc *time.Location
+	clk ptk.Clock
+
+	mux     sync.Mutex
+	jobs    map[string]*job
+	busy    map[string]bool // keyed by name so a replaced or re-added job can't overlap its old instance
+	onError func(name string, err error)
+
+	wake chan struct{}
+}
+
+// Add parses spec and adds or replaces the job with the given name.
+func (s *Scheduler) Add(name, spec string, fn func(ctx context.Context) error) error {
+	sch, err := ParseInLocation(spec, s.loc)
+	if err != nil {
+		return err
+	}
+
+	return s.AddSchedule(name, sch, fn)
+}
+
+// AddSchedule adds or replaces the job with the given name.
+func (s *Scheduler) AddSchedule(name string, sch Schedule, fn func(ctx context.Context) error) error {
+	if s.bg.IsCanceled() {
+		return context.Canceled
+	}
+
+	next := sch.Next(s.clk.Now())
+	if next.IsZero() {
+		return fmt.Errorf("cron: %s: schedule never activates", name)
+	}
+
+	s.mux.Lock()
+	s.jobs[name] = &job{name: name, sch: sch, fn: fn, next: next}
+	s.mux.Unlock()
+
+	s.notify()
+	return nil
+}
+
+// Remove removes the named job, it doesn't affect a currently running instance of it,
+// and a job added with the same name won't start until that instance returns.
+func (s *Scheduler) Remove(name string) (found bool) {
+	s.mux.Lock()
+	if _, found = s.jobs[name]; found {
+		delete(s.jobs, name)
+	}
+	s.mux.Unlock()
+
+	if found {
+		s.notify()
+	}
+
+	return
+}
+
+// Next returns the next activation time of the named job, or the zero time if it doesn't exist.
+func (s *Scheduler) Next(name string) (t time.Time) {
+	s.mux.Lock()
+	if j := s.jobs[name]; j != nil {
+		t = j.next
+	}
+	s.mux.Unlock()
+	return
+}
+
+// IsRunning returns true if the named job is currently running.
+func (s *Scheduler) IsRunning(name string) (running bool) {
+	s.mux.Lock()
+	running = s.busy[name]
+	s.mux.Unlock()
+	return
+}
+
+// OnError sets a func to be called with errors returned by jobs.
+func (s *Scheduler) OnError(fn func(name string, err error)) {
+	s.mux.Lock()
+	s.onError = fn
+	s.mux.Unlock()
+}
+
+// Close closes the underlying BackgroundLimiter.
+func (s *Scheduler) Close() error { return s.bg.Close() }
+
+func (s *Scheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Scheduler) loop() {
+	ctx := s.bg.Context()
+	for {
+		var (
+			now  = s.clk.Now()
+			wait = time.Hour
+		)
+
+		s.mux.Lock()
+		for _, j := range s.jobs {
+			if !j.next.After(now) {
+				if !s.busy[j.name] {
+					s.busy[j.name] = true
+					go s.run(j.name, j.fn, s.onError)
+				}
+				j.next = j.sch.Next(now)
+			}
+
+			if j.next.IsZero() {
+				delete(s.jobs, j.name)
+				continue
+			}
+
+			if d := j.next.Sub(now); d < wait {
+				wait = d
+			}
+		}
+		s.mux.Unlock()
+
+		t := s.clk.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-s.wake:
+			t.Stop()
+		case <-t.C():
+		}
+	}
+}
+
+func (s *Scheduler) run(name string, fn func(ctx context.Context) error, onError func(name string, err error)) {
+	defer func() {
+		s.mux.Lock()
+		delete(s.busy, name)
+		s.mux.Unlock()
+	}()
+
+	if err := <-s.bg.Add(fn); err != nil && onError != nil {
+		onError(name, err)
+	}
+}
+
+type job struct {
+	name string
+	sch  Schedule
+	fn   func(ctx context.Context) error
+	next time.Time
+}
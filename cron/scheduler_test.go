@@ -0,0 +1,184 @@
+package cron_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/PathDNA/ptk"
+	"github.com/PathDNA/ptk/cron"
+)
+
+func waitFor(t *testing.T, fn func() bool) {
+	t.Helper()
+	for i := 0; i < 500; i++ {
+		if fn() {
+			return
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+	t.Fatal("timed out")
+}
+
+func newScheduler(t *testing.T) (*cron.Scheduler, *ptk.FakeClock, time.Time) {
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	clk := ptk.NewFakeClock(start)
+	s := cron.NewWithClock(nil, nil, clk)
+	t.Cleanup(func() { s.Close() })
+	waitFor(t, func() bool { return clk.Timers() == 1 })
+	return s, clk, start
+}
+
+func TestSchedulerNoOverlap(t *testing.T) {
+	s, clk, start := newScheduler(t)
+
+	var (
+		runs, running, maxRunning int32
+		release                   = make(chan struct{})
+	)
+
+	s.AddSchedule("job", cron.Every(time.Second), func(ctx context.Context) error {
+		atomic.AddInt32(&runs, 1)
+		if n := atomic.AddInt32(&running, 1); n > atomic.LoadInt32(&maxRunning) {
+			atomic.StoreInt32(&maxRunning, n)
+		}
+		defer atomic.AddInt32(&running, -1)
+		<-release
+		return nil
+	})
+
+	clk.Advance(time.Second)
+	waitFor(t, func() bool { return s.IsRunning("job") && atomic.LoadInt32(&running) == 1 })
+
+	// due twice more while the first run blocks, both activations are skipped.
+	for i := 2; i <= 3; i++ {
+		waitFor(t, func() bool { return s.Next("job").Equal(start.Add(time.Duration(i) * time.Second)) })
+		clk.Advance(time.Second)
+	}
+	waitFor(t, func() bool { return s.Next("job").Equal(start.Add(4 * time.Second)) })
+
+	if n := atomic.LoadInt32(&runs); n != 1 {
+		t.Fatalf("expected 1 run, got %d", n)
+	}
+
+	close(release)
+	waitFor(t, func() bool { return !s.IsRunning("job") })
+
+	clk.Advance(time.Second)
+	waitFor(t, func() bool { return atomic.LoadInt32(&runs) == 2 })
+
+	if n := atomic.LoadInt32(&maxRunning); n != 1 {
+		t.Fatalf("the job overlapped itself %d times", n)
+	}
+}
+
+func TestSchedulerReplaceRemove(t *testing.T) {
+	s, clk, start := newScheduler(t)
+
+	ran := make(chan string, 10)
+	job := func(name string) func(ctx context.Context) error {
+		return func(ctx context.Context) error {
+			ran <- name
+			return nil
+		}
+	}
+
+	if err := s.Add("a", "* * * * *", job("old")); err != nil {
+		t.Fatal(err)
+	}
+	s.Add("a", "*/5 * * * * *", job("new"))
+	s.AddSchedule("b", cron.Every(10*time.Second), job("b"))
+
+	if next := s.Next("a"); !next.Equal(start.Add(5 * time.Second)) {
+		t.Fatalf("unexpected next activation: %v", next)
+	}
+
+	clk.Advance(5 * time.Second)
+	if name := <-ran; name != "new" {
+		t.Fatalf("expected the replaced job to run, got %s", name)
+	}
+
+	if !s.Remove("a") || s.Remove("a") {
+		t.Fatal("unexpected Remove result")
+	}
+
+	if !s.Next("a").IsZero() {
+		t.Fatal("a shouldn't have a next activation")
+	}
+
+	clk.Advance(5 * time.Second)
+	if name := <-ran; name != "b" {
+		t.Fatalf("expected b, got %s", name)
+	}
+
+	waitFor(t, func() bool { return !s.IsRunning("b") })
+	if len(ran) != 0 {
+		t.Fatalf("a ran after Remove: %s", <-ran)
+	}
+}
+
+func TestSchedulerOnError(t *testing.T) {
+	s, clk, _ := newScheduler(t)
+
+	type jobErr struct {
+		name string
+		err  error
+	}
+
+	var (
+		errs  = make(chan jobErr, 1)
+		errOh = errors.New("oh no")
+	)
+
+	s.OnError(func(name string, err error) { errs <- jobErr{name, err} })
+	s.AddSchedule("fail", cron.Every(time.Second), func(ctx context.Context) error { return errOh })
+
+	clk.Advance(time.Second)
+	if e := <-errs; e.name != "fail" || e.err != errOh {
+		t.Fatalf("unexpected error: %+v", e)
+	}
+
+	s.Close()
+	if err := s.AddSchedule("late", cron.Every(time.Second), func(ctx context.Context) error { return nil }); err != context.Canceled {
+		t.Fatalf("expected Canceled after Close, got %v", err)
+	}
+}
+
+func TestSchedulerReplaceWhileRunning(t *testing.T) {
+	s, clk, start := newScheduler(t)
+
+	var (
+		oldRuns, newRuns int32
+		release          = make(chan struct{})
+	)
+
+	s.AddSchedule("job", cron.Every(time.Second), func(ctx context.Context) error {
+		atomic.AddInt32(&oldRuns, 1)
+		<-release
+		return nil
+	})
+
+	clk.Advance(time.Second)
+	waitFor(t, func() bool { return atomic.LoadInt32(&oldRuns) == 1 })
+
+	// the replacement is due while the old instance still runs, so it's skipped.
+	s.AddSchedule("job", cron.Every(time.Second), func(ctx context.Context) error {
+		atomic.AddInt32(&newRuns, 1)
+		return nil
+	})
+	waitFor(t, func() bool { return s.Next("job").Equal(start.Add(2 * time.Second)) })
+	clk.Advance(time.Second)
+	waitFor(t, func() bool { return s.Next("job").Equal(start.Add(3 * time.Second)) })
+
+	if n := atomic.LoadInt32(&newRuns); n != 0 || !s.IsRunning("job") {
+		t.Fatalf("the replacement overlapped the old instance %d times", n)
+	}
+
+	close(release)
+	waitFor(t, func() bool { return !s.IsRunning("job") })
+
+	clk.Advance(time.Second)
+	waitFor(t, func() bool { return atomic.LoadInt32(&newRuns) == 1 })
+}
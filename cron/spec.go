@@ -0,0 +1,334 @@
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule returns the next activation time after t.
+type Schedule interface {
+	Next(t time.Time) time.Time
+}
+
+// Parse is an alias for ParseInLocation(spec, time.UTC)
+func Parse(spec string) (Schedule, error) { return ParseInLocation(spec, time.UTC) }
+
+// ParseInLocation parses a cron spec, times are matched against the wall clock in loc unless
+// the spec starts with `CRON_TZ=Zone` or `TZ=Zone`.
+// Supported formats:
+//   - 5 fields: minute hour day-of-month month day-of-week
+//   - 6 fields: second minute hour day-of-month month day-of-week
+//   - @yearly (@annually), @monthly, @weekly, @daily (@midnight), @hourly
+//   - @every duration, for example `@every 1h30m`
+//
+// Fields support `*`, `?`, lists (`1,5`), ranges (`1-5`), steps (`*/5`, `1-30/5`, `3/5`),
+// month names (JAN-DEC) and day names (SUN-SAT, 7 is also sunday).
+func ParseInLocation(spec string, loc *time.Location) (Schedule, error) {
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	spec = strings.TrimSpace(spec)
+	if strings.HasPrefix(spec, "CRON_TZ=") || strings.HasPrefix(spec, "TZ=") {
+		i := strings.IndexByte(spec, ' ')
+		if i == -1 {
+			return nil, fmt.Errorf("cron: missing spec after %q", spec)
+		}
+
+		var err error
+		if loc, err = time.LoadLocation(spec[strings.IndexByte(spec, '=')+1 : i]); err != nil {
+			return nil, fmt.Errorf("cron: %v", err)
+		}
+		spec = strings.TrimSpace(spec[i+1:])
+	}
+
+	if strings.HasPrefix(spec, "@every ") {
+		d, err := time.ParseDuration(strings.TrimSpace(spec[len("@every "):]))
+		if err != nil {
+			return nil, fmt.Errorf("cron: %v", err)
+		}
+		if d < time.Second {
+			return nil, fmt.Errorf("cron: @every duration must be at least 1s, got %v", d)
+		}
+		return Every(d), nil
+	}
+
+	if s, ok := shortcuts[spec]; ok {
+		spec = s
+	}
+
+	fields := strings.Fields(spec)
+	switch len(fields) {
+	case 5:
+		fields = append([]string{"0"}, fields...)
+	case 6:
+	default:
+		return nil, fmt.Errorf("cron: expected 5 or 6 fields, got %d: %q", len(fields), spec)
+	}
+
+	var (
+		s   = &SpecSchedule{loc: loc}
+		err error
+	)
+
+	for i, dst := range []*uint64{&s.second, &s.minute, &s.hour, &s.dom, &s.month, &s.dow} {
+		if *dst, err = parseField(fields[i], bounds[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// MustParse is like Parse but panics on error.
+func MustParse(spec string) Schedule {
+	s, err := Parse(spec)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// Every returns a Schedule that activates every d, rounded down to the second.
+func Every(d time.Duration) Schedule {
+	if d < time.Second {
+		d = time.Second
+	}
+	return every(d - d%time.Second)
+}
+
+type every time.Duration
+
+func (e every) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(e) - time.Duration(t.Nanosecond()))
+}
+
+// SpecSchedule is a parsed cron spec.
+// Times that don't exist in the location (DST spring forward) activate at the first instant after the gap,
+// times that happen twice (DST fall back) only activate once.
+type SpecSchedule struct {
+	second, minute, hour, dom, month, dow uint64
+
+	loc *time.Location
+}
+
+// Location returns the location the schedule is matched in.
+func (s *SpecSchedule) Location() *time.Location { return s.loc }
+
+// Next returns the next activation time after t, in the schedule's location,
+// or the zero time if nothing matches within 5 years.
+func (s *SpecSchedule) Next(t time.Time) time.Time {
+	t = t.In(s.loc)
+
+	// walk the wall clock as a zone-less civil time and only convert matches back to s.loc,
+	// that way DST changes can't make us skip or repeat fields.
+	var (
+		c     = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.UTC).Add(time.Second)
+		limit = c.Year() + 5
+	)
+
+	for c.Year() <= limit {
+		if c = s.nextCivil(c, limit); c.IsZero() {
+			break
+		}
+
+		n := time.Date(c.Year(), c.Month(), c.Day(), c.Hour(), c.Minute(), c.Second(), 0, s.loc)
+		if n.Hour() != c.Hour() || n.Minute() != c.Minute() {
+			// c is inside a DST gap, time.Date normalized it using the old offset
+			_, n = n.ZoneBounds()
+		}
+
+		if n.After(t) {
+			return n
+		}
+
+		c = c.Add(time.Second)
+	}
+
+	return time.Time{}
+}
+
+func (s *SpecSchedule) nextCivil(c time.Time, limit int) time.Time {
+WRAP:
+	if c.Year() > limit {
+		return time.Time{}
+	}
+
+	for !has(s.month, int(c.Month())) {
+		c = time.Date(c.Year(), c.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		if c.Month() == time.January {
+			goto WRAP
+		}
+	}
+
+	for !s.dayMatches(c) {
+		c = time.Date(c.Year(), c.Month(), c.Day()+1, 0, 0, 0, 0, time.UTC)
+		if c.Day() == 1 {
+			goto WRAP
+		}
+	}
+
+	for !has(s.hour, c.Hour()) {
+		c = c.Truncate(time.Hour).Add(time.Hour)
+		if c.Hour() == 0 {
+			goto WRAP
+		}
+	}
+
+	for !has(s.minute, c.Minute()) {
+		c = c.Truncate(time.Minute).Add(time.Minute)
+		if c.Minute() == 0 {
+			goto WRAP
+		}
+	}
+
+	for !has(s.second, c.Second()) {
+		c = c.Add(time.Second)
+		if c.Second() == 0 {
+			goto WRAP
+		}
+	}
+
+	return c
+}
+
+// dayMatches follows the classic cron rule: if both day-of-month and day-of-week are restricted,
+// a day matches if either of them does.
+func (s *SpecSchedule) dayMatches(c time.Time) bool {
+	var (
+		domMatch = has(s.dom, c.Day())
+		dowMatch = has(s.dow, int(c.Weekday()))
+	)
+
+	if s.dom&starBit != 0 || s.dow&starBit != 0 {
+		return domMatch && dowMatch
+	}
+
+	return domMatch || dowMatch
+}
+
+const starBit = 1 << 63
+
+type bound struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var (
+	bounds = [...]bound{
+		{name: "second", min: 0, max: 59},
+		{name: "minute", min: 0, max: 59},
+		{name: "hour", min: 0, max: 23},
+		{name: "day-of-month", min: 1, max: 31},
+		{name: "month", min: 1, max: 12, names: map[string]int{
+			"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+			"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+		}},
+		{name: "day-of-week", min: 0, max: 7, names: map[string]int{
+			"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+		}},
+	}
+
+	shortcuts = map[string]string{
+		"@yearly":   "0 0 0 1 1 *",
+		"@annually": "0 0 0 1 1 *",
+		"@monthly":  "0 0 0 1 * *",
+		"@weekly":   "0 0 0 * * 0",
+		"@daily":    "0 0 0 * * *",
+		"@midnight": "0 0 0 * * *",
+		"@hourly":   "0 0 * * * *",
+	}
+)
+
+func parseField(field string, b bound) (bits uint64, err error) {
+	for _, part := range strings.Split(field, ",") {
+		var pbits uint64
+		if pbits, err = parsePart(part, b); err != nil {
+			return
+		}
+		bits |= pbits
+	}
+
+	// 7 is an alias for sunday
+	if b.max == 7 && has(bits, 7) {
+		bits = bits&^(1<<7) | 1
+	}
+
+	return
+}
+
+func parsePart(part string, b bound) (bits uint64, err error) {
+	var (
+		rng, stepStr string
+		step         = 1
+		lo, hi       int
+		star         bool
+	)
+
+	if i := strings.IndexByte(part, '/'); i != -1 {
+		rng, stepStr = part[:i], part[i+1:]
+		if step, err = strconv.Atoi(stepStr); err != nil || step < 1 {
+			return 0, fmt.Errorf("cron: invalid step in %s field: %q", b.name, part)
+		}
+	} else {
+		rng = part
+	}
+
+	switch {
+	case rng == "*" || rng == "?":
+		lo, hi, star = b.min, b.max, stepStr == ""
+		if b.max == 7 {
+			hi = 6
+		}
+
+	case strings.IndexByte(rng, '-') > 0:
+		i := strings.IndexByte(rng, '-')
+		if lo, err = parseValue(rng[:i], b); err != nil {
+			return
+		}
+		if hi, err = parseValue(rng[i+1:], b); err != nil {
+			return
+		}
+
+	default:
+		if lo, err = parseValue(rng, b); err != nil {
+			return
+		}
+		hi = lo
+		if stepStr != "" {
+			hi = b.max
+		}
+	}
+
+	if lo > hi {
+		return 0, fmt.Errorf("cron: invalid range in %s field: %q", b.name, part)
+	}
+
+	for i := lo; i <= hi; i += step {
+		bits |= 1 << uint(i)
+	}
+
+	if star {
+		bits |= starBit
+	}
+
+	return
+}
+
+func parseValue(v string, b bound) (int, error) {
+	if n, ok := b.names[strings.ToLower(v)]; ok {
+		return n, nil
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil || n < b.min || n > b.max {
+		return 0, fmt.Errorf("cron: invalid value in %s field: %q", b.name, v)
+	}
+
+	return n, nil
+}
+
+func has(bits uint64, v int) bool { return bits&(1<<uint(v)) != 0 }
@@ -0,0 +1,77 @@
+package cron_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/PathDNA/ptk/cron"
+)
+
+func TestParse(t *testing.T) {
+	const layout = "2006-01-02 15:04:05"
+	tests := []struct {
+		spec, from, next string
+	}{
+		{"* * * * *", "2018-01-01 00:00:30", "2018-01-01 00:01:00"},
+		{"*/15 * * * * *", "2018-01-01 00:00:30", "2018-01-01 00:00:45"},
+		{"0 30 4 * * *", "2018-01-01 05:00:00", "2018-01-02 04:30:00"},
+		{"0 0 1 jan,jul *", "2018-02-01 00:00:00", "2018-07-01 00:00:00"},
+		{"0 0 * * mon-fri", "2018-01-06 12:00:00", "2018-01-08 00:00:00"},
+		{"0 0 * * 7", "2018-01-01 00:00:00", "2018-01-07 00:00:00"},
+		{"0 0 13 * 5", "2018-01-01 00:00:00", "2018-01-05 00:00:00"},
+		{"0 0 29 2 *", "2018-01-01 00:00:00", "2020-02-29 00:00:00"},
+		{"@daily", "2018-01-01 00:00:00", "2018-01-02 00:00:00"},
+		{"@every 90s", "2018-01-01 00:00:00", "2018-01-01 00:01:30"},
+	}
+
+	for _, tc := range tests {
+		s, err := cron.Parse(tc.spec)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.spec, err)
+		}
+		from, _ := time.Parse(layout, tc.from)
+		if next := s.Next(from).Format(layout); next != tc.next {
+			t.Fatalf("%s: expected %s, got %s", tc.spec, tc.next, next)
+		}
+	}
+
+	for _, spec := range []string{"* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "@every 1ms"} {
+		if _, err := cron.Parse(spec); err == nil {
+			t.Fatalf("%s: expected an error", spec)
+		}
+	}
+}
+
+func TestDST(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	// spring forward, 2:30 doesn't exist on 2018-03-11
+	s, _ := cron.ParseInLocation("30 2 * * *", loc)
+	next := s.Next(time.Date(2018, 3, 11, 1, 0, 0, 0, loc))
+	if exp := time.Date(2018, 3, 11, 3, 0, 0, 0, loc); !next.Equal(exp) {
+		t.Fatalf("expected %v, got %v", exp, next)
+	}
+	if next = s.Next(next); !next.Equal(time.Date(2018, 3, 12, 2, 30, 0, 0, loc)) {
+		t.Fatalf("unexpected %v", next)
+	}
+
+	// fall back, 1:30 happens twice on 2018-11-04 but should only run once
+	s, _ = cron.ParseInLocation("30 1 * * *", loc)
+	next = s.Next(time.Date(2018, 11, 4, 0, 0, 0, 0, loc))
+	if next = s.Next(next); !next.Equal(time.Date(2018, 11, 5, 1, 30, 0, 0, loc)) {
+		t.Fatalf("unexpected %v", next)
+	}
+
+	// hourly jobs keep firing across the gap
+	s, _ = cron.ParseInLocation("CRON_TZ=America/New_York 0 * * * *", time.UTC)
+	next = s.Next(time.Date(2018, 3, 11, 1, 0, 0, 0, loc))
+	if exp := time.Date(2018, 3, 11, 3, 0, 0, 0, loc); !next.Equal(exp) {
+		t.Fatalf("expected %v, got %v", exp, next)
+	}
+	if next = s.Next(next); !next.Equal(time.Date(2018, 3, 11, 4, 0, 0, 0, loc)) {
+		t.Fatalf("unexpected %v", next)
+	}
+}
@@ -3,6 +3,7 @@ package ptk
 import (
 	"fmt"
 	"runtime"
+	"runtime/debug"
 	"strconv"
 	"strings"
 )
@@ -20,6 +21,22 @@ func (e Error) Error() string {
 	return fmt.Sprintf("%s: %v", e.Msg, e.Err)
 }
 
+// PanicError is a recovered panic converted to an error.
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+// NewPanicError returns a *PanicError with the current goroutine's stack trace, it should be called from the deferred func that recovered v.
+// It returns a pointer so the error stays comparable.
+func NewPanicError(v interface{}) *PanicError {
+	return &PanicError{Value: v, Stack: debug.Stack()}
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v\n%s", e.Value, e.Stack)
+}
+
 type Errors []error
 
 func (es *Errors) Push(err error) (pushed bool) {
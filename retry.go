@@ -5,6 +5,29 @@ import (
 	"time"
 )
 
+// RetryOptions controls RetryWithOptions.
+type RetryOptions struct {
+	// Attempts is the max number of times fn gets called, defaults to 1.
+	Attempts uint
+	// Delay is the initial delay between attempts, defaults to 1 second.
+	Delay time.Duration
+	// BackoffMod is multiplied by the delay after each failed attempt, defaults to 1.
+	BackoffMod float64
+
+	// OnRetry, if set, is called after every failed attempt that will be retried, attempt starts at 1.
+	OnRetry func(attempt uint, err error, nextDelay time.Duration)
+
+	// Clock defaults to RealClock.
+	Clock Clock
+}
+
+// RetryResult is returned by RetryWithOptions.
+type RetryResult struct {
+	Attempts uint
+	Elapsed  time.Duration
+	Err      error
+}
+
 // Retry is an alias for RetryCtx(context.Background(), fn, attempts, delay, backoffMod)
 func Retry(fn func() error, attempts uint, delay time.Duration, backoffMod float64) error {
 	return RetryCtx(context.Background(), fn, attempts, delay, backoffMod)
@@ -12,30 +35,14 @@ func Retry(fn func() error, attempts uint, delay time.Duration, backoffMod float
 
 // RetryCtx calls fn every (delay * backoffMod) until it returns nil, the passed ctx is done or attempts are reached.
 func RetryCtx(ctx context.Context, fn func() error, attempts uint, delay time.Duration, backoffMod float64) error {
-	if delay == 0 {
-		delay = time.Second
-	}
-
-	if attempts == 0 {
-		attempts = 1
-	}
-
-	if backoffMod == 0 {
-		backoffMod = 1
-	}
-
 	ret := make(chan error, 1)
 
 	go func() {
-		var err error
-		for ; attempts > 0; attempts-- {
-			if err = fn(); err == nil {
-				break
-			}
-			time.Sleep(delay)
-			delay = time.Duration(float64(delay) * backoffMod)
-		}
-		ret <- err
+		ret <- RetryWithOptions(ctx, func(context.Context) error { return fn() }, RetryOptions{
+			Attempts:   attempts,
+			Delay:      delay,
+			BackoffMod: backoffMod,
+		}).Err
 	}()
 
 	select {
@@ -45,3 +52,69 @@ func RetryCtx(ctx context.Context, fn func() error, attempts uint, delay time.Du
 		return ctx.Err()
 	}
 }
+
+// RetryWithOptions calls fn until it returns nil, the passed ctx is done or opts.Attempts are reached.
+// The ctx passed to fn can be used with RetryAttempt to get the current attempt number.
+func RetryWithOptions(ctx context.Context, fn func(ctx context.Context) error, opts RetryOptions) (res RetryResult) {
+	if opts.Delay == 0 {
+		opts.Delay = time.Second
+	}
+
+	if opts.Attempts == 0 {
+		opts.Attempts = 1
+	}
+
+	if opts.BackoffMod == 0 {
+		opts.BackoffMod = 1
+	}
+
+	var (
+		clk   = ClockOrReal(opts.Clock)
+		start = clk.Now()
+		delay = opts.Delay
+	)
+
+	defer func() { res.Elapsed = clk.Since(start) }()
+
+	for res.Attempts < opts.Attempts {
+		res.Attempts++
+		if res.Err = fn(context.WithValue(ctx, retryAttemptKey{}, retryAttempt{res.Attempts, opts.Attempts})); res.Err == nil {
+			return
+		}
+
+		if res.Attempts == opts.Attempts {
+			return
+		}
+
+		if opts.OnRetry != nil {
+			opts.OnRetry(res.Attempts, res.Err, delay)
+		}
+
+		t := clk.NewTimer(delay)
+		select {
+		case <-t.C():
+		case <-ctx.Done():
+			t.Stop()
+			res.Err = ctx.Err()
+			return
+		}
+
+		delay = time.Duration(float64(delay) * opts.BackoffMod)
+	}
+
+	return
+}
+
+// RetryAttempt returns the current attempt (starting at 1) and the max number of attempts
+// if ctx was passed by RetryWithOptions, otherwise ok will be false.
+func RetryAttempt(ctx context.Context) (attempt, attempts uint, ok bool) {
+	ra, ok := ctx.Value(retryAttemptKey{}).(retryAttempt)
+	return ra.attempt, ra.attempts, ok
+}
+
+type retryAttemptKey struct{}
+
+type retryAttempt struct {
+	attempt  uint
+	attempts uint
+}
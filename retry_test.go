@@ -0,0 +1,133 @@
+package ptk_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/PathDNA/ptk"
+)
+
+var errNotYet = errors.New("not yet")
+
+// advanceWhenWaiting advances clk by d every time fn is blocked on one of its timers, until done is closed.
+func advanceWhenWaiting(clk *ptk.FakeClock, d time.Duration, done <-chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+
+		if clk.Timers() > 0 {
+			clk.Advance(d)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRetryWithOptions(t *testing.T) {
+	var (
+		clk    = ptk.NewFakeClock(time.Time{})
+		done   = make(chan struct{})
+		delays []time.Duration
+		calls  []uint
+	)
+
+	go advanceWhenWaiting(clk, time.Second, done)
+
+	res := ptk.RetryWithOptions(context.Background(), func(ctx context.Context) error {
+		n, max, ok := ptk.RetryAttempt(ctx)
+		if !ok || max != 4 {
+			t.Errorf("unexpected attempt info: %d/%d %v", n, max, ok)
+		}
+		calls = append(calls, n)
+		return errNotYet
+	}, ptk.RetryOptions{
+		Attempts:   4,
+		Delay:      time.Second,
+		BackoffMod: 2,
+		Clock:      clk,
+		OnRetry: func(attempt uint, err error, d time.Duration) {
+			if err != errNotYet || attempt != uint(len(delays)+1) {
+				t.Errorf("unexpected OnRetry(%d, %v)", attempt, err)
+			}
+			delays = append(delays, d)
+		},
+	})
+	close(done)
+
+	// the clock moves 1s per poll, so waiting 1s, 2s and 4s takes 7 ticks.
+	if res.Err != errNotYet || res.Attempts != 4 || res.Elapsed != 7*time.Second {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+
+	if len(calls) != 4 || calls[0] != 1 || calls[3] != 4 {
+		t.Fatalf("unexpected calls: %v", calls)
+	}
+
+	if len(delays) != 3 || delays[0] != time.Second || delays[1] != 2*time.Second || delays[2] != 4*time.Second {
+		t.Fatalf("unexpected delays: %v", delays)
+	}
+}
+
+func TestRetryCancel(t *testing.T) {
+	var (
+		clk         = ptk.NewFakeClock(time.Time{})
+		ctx, cancel = context.WithCancel(context.Background())
+		resCh       = make(chan ptk.RetryResult, 1)
+	)
+
+	go func() {
+		resCh <- ptk.RetryWithOptions(ctx, func(context.Context) error { return errNotYet }, ptk.RetryOptions{
+			Attempts: 10,
+			Delay:    time.Minute,
+			Clock:    clk,
+		})
+	}()
+
+	for clk.Timers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+
+	res := <-resCh
+	if res.Err != context.Canceled || res.Attempts != 1 {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+
+	if clk.Timers() != 0 {
+		t.Fatal("the delay timer should be stopped")
+	}
+}
+
+func TestRetryCtx(t *testing.T) {
+	var n int
+	err := ptk.Retry(func() error {
+		if n++; n < 3 {
+			return errNotYet
+		}
+		return nil
+	}, 5, time.Millisecond, 1)
+	if err != nil || n != 3 {
+		t.Fatalf("unexpected result: %v after %d calls", err, n)
+	}
+
+	n = 0
+	if err = ptk.Retry(func() error { n++; return errNotYet }, 2, time.Millisecond, 1); err != errNotYet || n != 2 {
+		t.Fatalf("unexpected result: %v after %d calls", err, n)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if err = ptk.RetryCtx(ctx, func() error { return errNotYet }, 100, time.Hour, 1); err != context.DeadlineExceeded {
+		t.Fatalf("expected DeadlineExceeded, got %v", err)
+	}
+
+	if time.Since(start) > time.Second {
+		t.Fatal("RetryCtx should return as soon as ctx is done")
+	}
+}
@@ -1,43 +1,142 @@
 package ptk
 
 import (
+	"container/list"
+	"context"
+	"errors"
+	"os"
 	"sync"
 )
 
+// ErrSemTooLarge is returned when trying to acquire more than the Sem's size.
+var ErrSemTooLarge = errors.New("ptk: acquire weight is larger than the sem size")
+
 func NewSem(size int) *Sem {
-	if size == 0 {
+	if size <= 0 {
 		size = 1
 	}
 
-	return &Sem{ch: make(chan struct{}, size)}
+	return &Sem{size: size}
 }
 
+// Sem is a weighted semaphore, waiters are served in FIFO order so large requests don't get starved by smaller ones.
 type Sem struct {
 	wg sync.WaitGroup
-	ch chan struct{}
+
+	mux     sync.Mutex
+	size    int
+	cur     int
+	waiters list.List
+	closed  bool
 }
 
-func (s *Sem) Add(n int) {
-	if n == 0 {
-		return
+type semWaiter struct {
+	n     int
+	err   error
+	ready chan struct{}
+}
+
+// Acquire acquires n permits, blocking until they are available, ctx is done or the sem is closed.
+func (s *Sem) Acquire(ctx context.Context, n int) error {
+	if n <= 0 {
+		return nil
 	}
 
-	if n > 0 {
-		var e struct{}
-		for i := 0; i < n; i++ {
-			s.ch <- e
-		}
-	} else {
-		for i := 0; i > n; i-- {
-			<-s.ch
+	s.mux.Lock()
+	switch {
+	case s.closed:
+		s.mux.Unlock()
+		return os.ErrClosed
+	case n > s.size:
+		s.mux.Unlock()
+		return ErrSemTooLarge
+	case s.cur+n <= s.size && s.waiters.Len() == 0:
+		s.cur += n
+		s.wg.Add(n)
+		s.mux.Unlock()
+		return nil
+	}
+
+	w := &semWaiter{n: n, ready: make(chan struct{})}
+	elem := s.waiters.PushBack(w)
+	s.mux.Unlock()
+
+	select {
+	case <-w.ready:
+		return w.err
+
+	case <-ctx.Done():
+		s.mux.Lock()
+		select {
+		case <-w.ready:
+			// got notified right as ctx was done, give the permits back.
+			s.mux.Unlock()
+			if w.err == nil {
+				s.Release(n)
+			}
+		default:
+			front := s.waiters.Front() == elem
+			s.waiters.Remove(elem)
+			// if we were blocking the queue, let the next waiters through.
+			if front && s.cur < s.size {
+				s.notifyWaiters()
+			}
+			s.mux.Unlock()
 		}
+		return ctx.Err()
+	}
+}
+
+// TryAcquire acquires n permits without blocking, returns false if they aren't available.
+func (s *Sem) TryAcquire(n int) bool {
+	if n <= 0 {
+		return true
+	}
+
+	s.mux.Lock()
+	ok := !s.closed && s.cur+n <= s.size && s.waiters.Len() == 0
+	if ok {
+		s.cur += n
+		s.wg.Add(n)
+	}
+	s.mux.Unlock()
+	return ok
+}
+
+// Release releases n permits.
+func (s *Sem) Release(n int) {
+	if n <= 0 {
+		return
 	}
 
-	s.wg.Add(n)
+	s.mux.Lock()
+	if s.cur -= n; s.cur < 0 {
+		s.mux.Unlock()
+		panic("ptk: sem released more than held")
+	}
+	s.notifyWaiters()
+	s.mux.Unlock()
+
+	s.wg.Add(-n)
+}
+
+// Add acquires n permits if n > 0 or releases -n permits if n < 0.
+// Unlike Acquire, it blocks until the permits are available,
+// and panics with os.ErrClosed if the sem is closed or ErrSemTooLarge if n is larger than its size.
+func (s *Sem) Add(n int) {
+	if n < 0 {
+		s.Release(-n)
+	} else if err := s.Acquire(context.Background(), n); err != nil {
+		panic(err)
+	}
 }
 
+// Run runs fn in a goroutine once a permit is available, fn doesn't run if the sem is closed.
 func (s *Sem) Run(fn func()) {
-	s.Add(1)
+	if s.Acquire(context.Background(), 1) != nil {
+		return
+	}
+
 	go func() {
 		defer s.Done()
 		fn()
@@ -48,8 +147,84 @@ func (s *Sem) Done() {
 	s.Add(-1)
 }
 
+// Wait waits until all the acquired permits are released.
 func (s *Sem) Wait() {
 	s.wg.Wait()
 }
 
-func (s *Sem) Close() { close(s.ch) }
+// SetLimit changes the sem size, in-flight permits are kept even if they're over the new size,
+// pending Acquire calls that can never fit return ErrSemTooLarge.
+func (s *Sem) SetLimit(size int) {
+	if size <= 0 {
+		size = 1
+	}
+
+	s.mux.Lock()
+	s.size = size
+	for e := s.waiters.Front(); e != nil; {
+		next := e.Next()
+		if w := e.Value.(*semWaiter); w.n > size {
+			w.err = ErrSemTooLarge
+			s.waiters.Remove(e)
+			close(w.ready)
+		}
+		e = next
+	}
+	s.notifyWaiters()
+	s.mux.Unlock()
+}
+
+// Limit returns the current sem size.
+func (s *Sem) Limit() int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.size
+}
+
+// InFlight returns the number of acquired permits.
+func (s *Sem) InFlight() int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.cur
+}
+
+// Waiting returns the number of blocked Acquire calls.
+func (s *Sem) Waiting() int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.waiters.Len()
+}
+
+// Close makes all pending and future Acquire calls return os.ErrClosed.
+func (s *Sem) Close() {
+	s.mux.Lock()
+	s.closed = true
+	for e := s.waiters.Front(); e != nil; e = e.Next() {
+		w := e.Value.(*semWaiter)
+		w.err = os.ErrClosed
+		close(w.ready)
+	}
+	s.waiters.Init()
+	s.mux.Unlock()
+}
+
+// notifyWaiters must be called with s.mux held.
+func (s *Sem) notifyWaiters() {
+	for {
+		e := s.waiters.Front()
+		if e == nil {
+			break
+		}
+
+		w := e.Value.(*semWaiter)
+		if s.cur+w.n > s.size {
+			// keep the FIFO order so large requests don't starve.
+			break
+		}
+
+		s.cur += w.n
+		s.wg.Add(w.n)
+		s.waiters.Remove(e)
+		close(w.ready)
+	}
+}
@@ -0,0 +1,88 @@
+package ptk_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/PathDNA/ptk"
+)
+
+func TestSemWeighted(t *testing.T) {
+	s := ptk.NewSem(4)
+	if err := s.Acquire(context.Background(), 3); err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(chan int, 2)
+	go func() {
+		s.Acquire(context.Background(), 4)
+		got <- 4
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	if s.TryAcquire(1) {
+		t.Fatal("small requests shouldn't skip ahead of the waiting large one")
+	}
+
+	go func() {
+		s.Acquire(context.Background(), 1)
+		got <- 1
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := s.Acquire(ctx, 1); err != context.DeadlineExceeded {
+		t.Fatalf("expected DeadlineExceeded, got %v", err)
+	}
+
+	s.Release(3)
+	if n := <-got; n != 4 {
+		t.Fatalf("expected 4, got %d", n)
+	}
+
+	s.Release(4)
+	if n := <-got; n != 1 {
+		t.Fatalf("expected 1, got %d", n)
+	}
+
+	s.Release(1)
+	s.Wait()
+
+	if err := s.Acquire(context.Background(), 5); err != ptk.ErrSemTooLarge {
+		t.Fatalf("expected ErrSemTooLarge, got %v", err)
+	}
+
+	s.Acquire(context.Background(), 4)
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		s.Close()
+	}()
+
+	if err := s.Acquire(context.Background(), 1); err != os.ErrClosed {
+		t.Fatalf("expected os.ErrClosed, got %v", err)
+	}
+}
+
+func TestSemAddPanics(t *testing.T) {
+	addErr := func(s *ptk.Sem, n int) (err interface{}) {
+		defer func() { err = recover() }()
+		s.Add(n)
+		return
+	}
+
+	s := ptk.NewSem(2)
+	if err := addErr(s, 3); err != ptk.ErrSemTooLarge {
+		t.Fatalf("expected ErrSemTooLarge, got %v", err)
+	}
+
+	s.Close()
+	if err := addErr(s, 1); err != os.ErrClosed {
+		t.Fatalf("expected os.ErrClosed, got %v", err)
+	}
+
+	if s.InFlight() != 0 {
+		t.Fatal("a failed Add shouldn't hold any permits")
+	}
+}
@@ -0,0 +1,110 @@
+package ptk
+
+import (
+	"context"
+	"math"
+	"sync"
+)
+
+// NewSemGroup returns a SemGroup that runs at most limit funcs at a time, if limit <= 0 it won't have a limit,
+// and the context passed to the funcs.
+// If collectAll is false, the context gets canceled on the first error and Wait returns that error,
+// otherwise all the funcs run and Wait returns all their errors as Errors.
+func NewSemGroup(ctx context.Context, limit int, collectAll bool) (*SemGroup, context.Context) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if limit <= 0 {
+		limit = math.MaxInt32
+	}
+
+	g := &SemGroup{
+		sem:        NewSem(limit),
+		collectAll: collectAll,
+	}
+	g.ctx, g.cancel = context.WithCancel(ctx)
+
+	return g, g.ctx
+}
+
+// SemGroup is an errgroup-like helper on top of Sem, panics in funcs are recovered and returned as *PanicError.
+type SemGroup struct {
+	sem *Sem
+
+	ctx    context.Context
+	cancel func()
+
+	collectAll bool
+
+	mux  sync.Mutex
+	err  error
+	errs Errors
+}
+
+// Go blocks until a slot is available then runs fn in a goroutine,
+// if the group's context is done before that, fn doesn't run.
+func (g *SemGroup) Go(fn func(ctx context.Context) error) {
+	if err := g.sem.Acquire(g.ctx, 1); err != nil {
+		g.setErr(err)
+		return
+	}
+
+	// Acquire doesn't check ctx if a slot is free right away.
+	if err := g.ctx.Err(); err != nil {
+		g.sem.Done()
+		g.setErr(err)
+		return
+	}
+
+	go func() {
+		defer g.sem.Done()
+		g.setErr(g.run(fn))
+	}()
+}
+
+// Wait waits for all the funcs to return, cancels the group's context and returns the error(s).
+func (g *SemGroup) Wait() error {
+	g.sem.Wait()
+	g.cancel()
+
+	g.mux.Lock()
+	defer g.mux.Unlock()
+
+	if g.collectAll {
+		return g.errs.Err()
+	}
+
+	return g.err
+}
+
+func (g *SemGroup) run(fn func(ctx context.Context) error) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = NewPanicError(v)
+		}
+	}()
+
+	return fn(g.ctx)
+}
+
+func (g *SemGroup) setErr(err error) {
+	if err == nil {
+		return
+	}
+
+	g.mux.Lock()
+	if g.collectAll {
+		// only report the ctx error once, otherwise every skipped func would add it.
+		if err != g.ctx.Err() || g.err == nil {
+			g.errs.Push(err)
+		}
+	} else if g.err == nil {
+		g.cancel()
+	}
+
+	if g.err == nil {
+		g.err = err
+	}
+	g.mux.Unlock()
+}
@@ -0,0 +1,142 @@
+package ptk_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/PathDNA/ptk"
+)
+
+func TestSemGroupFirstError(t *testing.T) {
+	var (
+		g, ctx = ptk.NewSemGroup(context.Background(), 2, false)
+		errA   = errors.New("a")
+		ran    int32
+	)
+
+	g.Go(func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	g.Go(func(ctx context.Context) error { return errA })
+
+	<-ctx.Done()
+
+	// a slot is free by now, but the group is canceled so fn shouldn't run.
+	time.Sleep(10 * time.Millisecond)
+	g.Go(func(ctx context.Context) error {
+		atomic.StoreInt32(&ran, 1)
+		return nil
+	})
+
+	if err := g.Wait(); err != errA {
+		t.Fatalf("expected %v, got %v", errA, err)
+	}
+
+	if atomic.LoadInt32(&ran) == 1 {
+		t.Fatal("fn ran after the group was canceled")
+	}
+}
+
+func TestSemGroupCollectAll(t *testing.T) {
+	var (
+		g, _ = ptk.NewSemGroup(context.Background(), 0, true)
+		errA = errors.New("a")
+		errB = errors.New("b")
+		ran  int32
+	)
+
+	for _, err := range []error{errA, nil, errB, nil} {
+		err := err
+		g.Go(func(ctx context.Context) error {
+			atomic.AddInt32(&ran, 1)
+			time.Sleep(time.Millisecond)
+			return err
+		})
+	}
+
+	err := g.Wait()
+	es, _ := err.(ptk.Errors)
+	if len(es) != 2 || atomic.LoadInt32(&ran) != 4 {
+		t.Fatalf("expected all 4 funcs to run and 2 errors, got %d and %v", ran, err)
+	}
+
+	if !(es[0] == errA && es[1] == errB) && !(es[0] == errB && es[1] == errA) {
+		t.Fatalf("unexpected errors: %v", es)
+	}
+}
+
+func TestSemGroupCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for _, collectAll := range []bool{false, true} {
+		var (
+			g, _ = ptk.NewSemGroup(ctx, 1, collectAll)
+			ran  int32
+		)
+
+		for i := 0; i < 3; i++ {
+			g.Go(func(ctx context.Context) error {
+				atomic.AddInt32(&ran, 1)
+				return nil
+			})
+		}
+
+		err := g.Wait()
+		if ran != 0 {
+			t.Fatalf("collectAll=%v: %d funcs ran with a canceled ctx", collectAll, ran)
+		}
+
+		if collectAll {
+			// the ctx error is only reported once.
+			if es, _ := err.(ptk.Errors); len(es) != 1 || es[0] != context.Canceled {
+				t.Fatalf("expected a single Canceled, got %v", err)
+			}
+		} else if err != context.Canceled {
+			t.Fatalf("expected Canceled, got %v", err)
+		}
+	}
+}
+
+func TestSemGroupPanic(t *testing.T) {
+	g, _ := ptk.NewSemGroup(context.Background(), 1, false)
+	g.Go(func(ctx context.Context) error { panic("boom") })
+
+	var pe *ptk.PanicError
+	if err := g.Wait(); !errors.As(err, &pe) || pe.Value != "boom" || len(pe.Stack) == 0 {
+		t.Fatalf("expected a *PanicError, got %#v", err)
+	}
+}
+
+func TestSemGroupLimit(t *testing.T) {
+	var (
+		g, _         = ptk.NewSemGroup(context.Background(), 3, false)
+		running, max int32
+	)
+
+	for i := 0; i < 10; i++ {
+		g.Go(func(ctx context.Context) error {
+			n := atomic.AddInt32(&running, 1)
+			for {
+				if m := atomic.LoadInt32(&max); n <= m || atomic.CompareAndSwapInt32(&max, m, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+			return nil
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	if max := atomic.LoadInt32(&max); max != 3 {
+		t.Fatalf("expected at most 3 concurrent funcs, got %d", max)
+	}
+}
@@ -2,6 +2,7 @@ package ptk
 
 import (
 	"context"
+	"sync"
 	"time"
 )
 
@@ -14,9 +15,124 @@ func WaitFor(fn func() error) <-chan error {
 	return ch
 }
 
+// WaitAll runs fns concurrently, limit at a time if limit > 0, and returns a channel that
+// receives nil or the non-nil errors as Errors, in the same order as fns, once all of them return.
+func WaitAll(ctx context.Context, limit int, fns ...func(ctx context.Context) error) <-chan error {
+	errs := make([]error, len(fns))
+	return waitN(ctx, limit, fns, func(i int, err error) (error, bool) {
+		errs[i] = err
+		return nil, false
+	}, func() error {
+		var es Errors
+		for _, err := range errs {
+			es.Push(err)
+		}
+		return es.Err()
+	})
+}
+
+// WaitAny runs fns concurrently, limit at a time if limit > 0, and returns a channel that
+// receives the result of the first fn that returns, the ctx passed to the rest gets canceled.
+func WaitAny(ctx context.Context, limit int, fns ...func(ctx context.Context) error) <-chan error {
+	return waitN(ctx, limit, fns, func(_ int, err error) (error, bool) {
+		return err, true
+	}, func() error { return nil })
+}
+
+// WaitFirstSuccess runs fns concurrently, limit at a time if limit > 0, and returns a channel that
+// receives nil as soon as one of them succeeds, the ctx passed to the rest gets canceled.
+// If all of them fail, it receives all the errors as Errors, in the same order as fns.
+func WaitFirstSuccess(ctx context.Context, limit int, fns ...func(ctx context.Context) error) <-chan error {
+	errs := make([]error, len(fns))
+	return waitN(ctx, limit, fns, func(i int, err error) (error, bool) {
+		errs[i] = err
+		return nil, err == nil
+	}, func() error {
+		var es Errors
+		for _, err := range errs {
+			es.Push(err)
+		}
+		return es.Err()
+	})
+}
+
+// waitN runs fns and calls onResult with every result until it returns true, or onDone after all of them returned.
+func waitN(ctx context.Context, limit int, fns []func(ctx context.Context) error,
+	onResult func(i int, err error) (error, bool), onDone func() error) <-chan error {
+	type result struct {
+		i   int
+		err error
+	}
+
+	var (
+		ch      = make(chan error, 1)
+		results = make(chan result, len(fns))
+		sem     *Sem
+	)
+
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if limit > 0 {
+		sem = NewSem(limit)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	go func() {
+		for i, fn := range fns {
+			if sem != nil {
+				if err := sem.Acquire(ctx, 1); err != nil {
+					results <- result{i, err}
+					continue
+				}
+			}
+
+			if err := ctx.Err(); err != nil {
+				results <- result{i, err}
+				if sem != nil {
+					sem.Done()
+				}
+				continue
+			}
+
+			go func(i int, fn func(ctx context.Context) error) {
+				if sem != nil {
+					defer sem.Done()
+				}
+				results <- result{i, fn(ctx)}
+			}(i, fn)
+		}
+	}()
+
+	go func() {
+		defer close(ch)
+		defer cancel()
+
+		for range fns {
+			r := <-results
+			if err, stop := onResult(r.i, r.err); stop {
+				ch <- err
+				return
+			}
+		}
+
+		ch <- onDone()
+	}()
+
+	return ch
+}
+
+// SleepUntil is an alias for SleepUntilWithClock(ctx, RealClock, hour, min, sec)
 func SleepUntil(ctx context.Context, hour, min, sec int) error {
+	return SleepUntilWithClock(ctx, RealClock, hour, min, sec)
+}
+
+// SleepUntilWithClock sleeps until the next hour:min:sec UTC according to clk, or until ctx is done.
+func SleepUntilWithClock(ctx context.Context, clk Clock, hour, min, sec int) error {
 	var (
-		now    = time.Now().UTC()
+		now    = ClockOrReal(clk).Now().UTC()
 		target = time.Date(now.Year(), now.Month(), now.Day(), hour, min, sec, 0, time.UTC)
 	)
 
@@ -28,15 +144,332 @@ func SleepUntil(ctx context.Context, hour, min, sec int) error {
 		ctx = context.Background()
 	}
 
-	t := time.NewTimer(target.Sub(now))
+	t := ClockOrReal(clk).NewTimer(target.Sub(now))
 
 	select {
-	case <-t.C:
+	case <-t.C():
 		return nil
 	case <-ctx.Done():
-		if !t.Stop() {
-			<-t.C
-		}
+		t.Stop()
 		return ctx.Err()
 	}
 }
+
+// Edge controls when a Debouncer or a Throttler calls its func.
+type Edge uint8
+
+const (
+	// EdgeTrailing calls the func at the end of a burst of triggers.
+	EdgeTrailing Edge = 1 << iota
+	// EdgeLeading calls the func on the first trigger of a burst.
+	EdgeLeading
+)
+
+// DebounceOptions are passed to NewDebouncer.
+type DebounceOptions struct {
+	// Wait is how long to wait after the last trigger before a burst is considered over.
+	Wait time.Duration
+	// MaxWait, if > 0, is the max time a burst can delay the func call.
+	MaxWait time.Duration
+	// Edge defaults to EdgeTrailing.
+	Edge Edge
+
+	// Clock defaults to RealClock.
+	Clock Clock
+}
+
+// ThrottleOptions are passed to NewThrottler.
+type ThrottleOptions struct {
+	// Interval is the minimum time between func calls.
+	Interval time.Duration
+	// Edge defaults to EdgeLeading | EdgeTrailing.
+	Edge Edge
+
+	// Clock defaults to RealClock.
+	Clock Clock
+}
+
+// NewDebouncer returns a Debouncer that coalesces bursts of Trigger calls into a single fn call.
+// Once ctx is done, pending calls are dropped and Trigger becomes a no-op.
+func NewDebouncer(ctx context.Context, fn func(), opts DebounceOptions) *Debouncer {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if opts.Edge == 0 {
+		opts.Edge = EdgeTrailing
+	}
+
+	d := &Debouncer{
+		fn:   fn,
+		opts: opts,
+		clk:  ClockOrReal(opts.Clock),
+	}
+
+	context.AfterFunc(ctx, d.Stop)
+
+	return d
+}
+
+// Debouncer is a goroutine-safe debouncer, fn calls never overlap.
+type Debouncer struct {
+	fn   func()
+	opts DebounceOptions
+	clk  Clock
+
+	mux     sync.Mutex
+	timer   Timer
+	gen     uint64
+	start   time.Time
+	pending bool
+	stopped bool
+
+	runMux sync.Mutex
+}
+
+// Trigger starts or extends the current burst.
+func (d *Debouncer) Trigger() {
+	d.mux.Lock()
+	if d.stopped {
+		d.mux.Unlock()
+		return
+	}
+
+	var (
+		now     = d.clk.Now()
+		wait    = d.opts.Wait
+		leading bool
+	)
+
+	if d.start.IsZero() {
+		d.start = now
+		leading = d.opts.Edge&EdgeLeading != 0
+		d.pending = !leading
+	} else {
+		d.pending = d.opts.Edge&EdgeTrailing != 0
+	}
+
+	if d.opts.MaxWait > 0 {
+		if left := d.start.Add(d.opts.MaxWait).Sub(now); left < wait {
+			wait = left
+		}
+	}
+
+	d.arm(wait)
+	d.mux.Unlock()
+
+	if leading {
+		d.run()
+	}
+}
+
+// Flush ends the current burst and calls fn if a trailing call is pending.
+func (d *Debouncer) Flush() {
+	d.mux.Lock()
+	pending := d.reset()
+	d.mux.Unlock()
+
+	if pending {
+		d.run()
+	}
+}
+
+// Stop drops any pending call and makes Trigger a no-op.
+func (d *Debouncer) Stop() {
+	d.mux.Lock()
+	d.reset()
+	d.stopped = true
+	d.mux.Unlock()
+}
+
+// IsPending returns true if a trailing call is pending.
+func (d *Debouncer) IsPending() bool {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	return d.pending
+}
+
+func (d *Debouncer) arm(wait time.Duration) {
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+
+	// a FakeClock fires non-positive timers synchronously, and we're holding d.mux
+	if wait <= 0 {
+		wait = 1
+	}
+
+	d.gen++
+	gen := d.gen
+	d.timer = d.clk.AfterFunc(wait, func() {
+		d.mux.Lock()
+		if gen != d.gen {
+			d.mux.Unlock()
+			return
+		}
+		pending := d.reset()
+		d.mux.Unlock()
+
+		if pending {
+			d.run()
+		}
+	})
+}
+
+// reset must be called with d.mux held.
+func (d *Debouncer) reset() (pending bool) {
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+
+	d.gen++
+	pending = d.pending && d.opts.Edge&EdgeTrailing != 0
+	d.start, d.pending = time.Time{}, false
+	return
+}
+
+func (d *Debouncer) run() {
+	d.runMux.Lock()
+	defer d.runMux.Unlock()
+	d.fn()
+}
+
+// NewThrottler returns a Throttler that calls fn at most once every opts.Interval no matter how often Trigger is called.
+// Once ctx is done, pending calls are dropped and Trigger becomes a no-op.
+func NewThrottler(ctx context.Context, fn func(), opts ThrottleOptions) *Throttler {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if opts.Edge == 0 {
+		opts.Edge = EdgeLeading | EdgeTrailing
+	}
+
+	// a FakeClock fires non-positive timers synchronously, and we're holding t.mux
+	if opts.Interval <= 0 {
+		opts.Interval = 1
+	}
+
+	t := &Throttler{
+		fn:   fn,
+		opts: opts,
+		clk:  ClockOrReal(opts.Clock),
+	}
+
+	context.AfterFunc(ctx, t.Stop)
+
+	return t
+}
+
+// Throttler is a goroutine-safe throttler, fn calls never overlap.
+type Throttler struct {
+	fn   func()
+	opts ThrottleOptions
+	clk  Clock
+
+	mux sync.Mutex
+	// timer is armed for opts.Interval after every call, Trigger only calls fn right away if it's nil.
+	timer   Timer
+	gen     uint64
+	pending bool
+	stopped bool
+
+	runMux sync.Mutex
+}
+
+// Trigger calls fn right away or schedules a call depending on the throttler's Edge.
+func (t *Throttler) Trigger() {
+	t.mux.Lock()
+	if t.stopped {
+		t.mux.Unlock()
+		return
+	}
+
+	var leading bool
+	switch {
+	case t.timer != nil:
+		t.pending = t.opts.Edge&EdgeTrailing != 0
+	case t.opts.Edge&EdgeLeading != 0:
+		leading = true
+		t.arm()
+	default:
+		t.pending = true
+		t.arm()
+	}
+	t.mux.Unlock()
+
+	if leading {
+		t.run()
+	}
+}
+
+// Flush calls fn if a trailing call is pending.
+func (t *Throttler) Flush() {
+	t.mux.Lock()
+	pending := t.pending
+	if pending {
+		t.pending = false
+		t.arm()
+	}
+	t.mux.Unlock()
+
+	if pending {
+		t.run()
+	}
+}
+
+// Stop drops any pending call and makes Trigger a no-op.
+func (t *Throttler) Stop() {
+	t.mux.Lock()
+	if t.timer != nil {
+		t.timer.Stop()
+		t.timer = nil
+	}
+	t.gen++
+	t.pending, t.stopped = false, true
+	t.mux.Unlock()
+}
+
+// IsPending returns true if a trailing call is pending.
+func (t *Throttler) IsPending() bool {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	return t.pending
+}
+
+// arm starts a new interval, it must be called with t.mux held.
+func (t *Throttler) arm() {
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+
+	t.gen++
+	gen := t.gen
+	t.timer = t.clk.AfterFunc(t.opts.Interval, func() {
+		t.mux.Lock()
+		if gen != t.gen {
+			t.mux.Unlock()
+			return
+		}
+
+		pending := t.pending
+		if pending {
+			t.pending = false
+			t.arm()
+		} else {
+			t.timer = nil
+		}
+		t.mux.Unlock()
+
+		if pending {
+			t.run()
+		}
+	})
+}
+
+func (t *Throttler) run() {
+	t.runMux.Lock()
+	defer t.runMux.Unlock()
+	t.fn()
+}
@@ -0,0 +1,342 @@
+package ptk_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/PathDNA/ptk"
+)
+
+type waitFn = func(ctx context.Context, limit int, fns ...func(ctx context.Context) error) <-chan error
+
+func after(d time.Duration, err error) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		select {
+		case <-time.After(d):
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func TestWait(t *testing.T) {
+	var (
+		errA = errors.New("a")
+		errB = errors.New("b")
+		ms   = time.Millisecond
+	)
+
+	tests := []struct {
+		name  string
+		wait  waitFn
+		limit int
+		fns   []func(ctx context.Context) error
+		want  error
+	}{
+		{"all/none", ptk.WaitAll, 0, nil, nil},
+		{"all/ok", ptk.WaitAll, 0, []func(ctx context.Context) error{after(ms, nil), after(0, nil)}, nil},
+		{"all/order", ptk.WaitAll, 0, []func(ctx context.Context) error{after(20*ms, errA), after(0, nil), after(0, errB)}, ptk.Errors{errA, errB}},
+		{"all/limit", ptk.WaitAll, 1, []func(ctx context.Context) error{after(10*ms, errA), after(0, errB)}, ptk.Errors{errA, errB}},
+		{"any/none", ptk.WaitAny, 0, nil, nil},
+		{"any/first", ptk.WaitAny, 0, []func(ctx context.Context) error{after(time.Second, nil), after(0, errA)}, errA},
+		{"any/limit", ptk.WaitAny, 1, []func(ctx context.Context) error{after(10*ms, errB), after(0, errA)}, errB},
+		{"success/none", ptk.WaitFirstSuccess, 0, nil, nil},
+		{"success/first", ptk.WaitFirstSuccess, 0, []func(ctx context.Context) error{after(0, errA), after(time.Second, errB), after(10*ms, nil)}, nil},
+		{"success/order", ptk.WaitFirstSuccess, 2, []func(ctx context.Context) error{after(20*ms, errA), after(0, errB)}, ptk.Errors{errA, errB}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := <-tc.wait(context.Background(), tc.limit, tc.fns...)
+			if es, ok := tc.want.(ptk.Errors); ok {
+				got, _ := err.(ptk.Errors)
+				if len(got) != len(es) {
+					t.Fatalf("expected %v, got %v", tc.want, err)
+				}
+				for i := range es {
+					if got[i] != es[i] {
+						t.Fatalf("expected %v, got %v", tc.want, err)
+					}
+				}
+				return
+			}
+
+			if err != tc.want {
+				t.Fatalf("expected %v, got %v", tc.want, err)
+			}
+		})
+	}
+}
+
+func TestWaitCancelsLosers(t *testing.T) {
+	for name, wait := range map[string]waitFn{"any": ptk.WaitAny, "success": ptk.WaitFirstSuccess} {
+		t.Run(name, func(t *testing.T) {
+			var (
+				running int32
+				fns     = []func(ctx context.Context) error{after(0, nil)}
+			)
+
+			for i := 0; i < 5; i++ {
+				fns = append(fns, func(ctx context.Context) error {
+					atomic.AddInt32(&running, 1)
+					defer atomic.AddInt32(&running, -1)
+					<-ctx.Done()
+					return ctx.Err()
+				})
+			}
+
+			if err := <-wait(context.Background(), 3, fns...); err != nil {
+				t.Fatal(err)
+			}
+
+			// every loser that got to run must see its ctx canceled and return.
+			for i := 0; atomic.LoadInt32(&running) > 0; i++ {
+				if i == 1000 {
+					t.Fatalf("%d fns are still running", atomic.LoadInt32(&running))
+				}
+				time.Sleep(time.Millisecond)
+			}
+
+			time.Sleep(10 * time.Millisecond)
+			if n := atomic.LoadInt32(&running); n != 0 {
+				t.Fatalf("%d fns started after the result was sent", n)
+			}
+		})
+	}
+}
+
+func TestWaitLimit(t *testing.T) {
+	var (
+		running, max int32
+		fns          []func(ctx context.Context) error
+	)
+
+	for i := 0; i < 8; i++ {
+		fns = append(fns, func(ctx context.Context) error {
+			n := atomic.AddInt32(&running, 1)
+			for {
+				if m := atomic.LoadInt32(&max); n <= m || atomic.CompareAndSwapInt32(&max, m, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+			return nil
+		})
+	}
+
+	if err := <-ptk.WaitAll(context.Background(), 3, fns...); err != nil {
+		t.Fatal(err)
+	}
+
+	if max := atomic.LoadInt32(&max); max != 3 {
+		t.Fatalf("expected at most 3 concurrent fns, got %d", max)
+	}
+}
+
+// recorder returns a fn that records the clock's time of every call relative to start.
+func recorder(clk *ptk.FakeClock) (fn func(), calls func() []time.Duration) {
+	var (
+		start = clk.Now()
+		mux   sync.Mutex
+		ts    []time.Duration
+	)
+
+	return func() {
+			mux.Lock()
+			ts = append(ts, clk.Since(start))
+			mux.Unlock()
+		}, func() []time.Duration {
+			mux.Lock()
+			defer mux.Unlock()
+			return append([]time.Duration(nil), ts...)
+		}
+}
+
+// triggerAt advances clk to every offset from its current time and calls trigger, then advances to end.
+func triggerAt(clk *ptk.FakeClock, trigger func(), end time.Duration, offsets ...time.Duration) {
+	start := clk.Now()
+	for _, off := range offsets {
+		clk.Set(start.Add(off))
+		trigger()
+	}
+	clk.Set(start.Add(end))
+}
+
+func equalDurations(a, b []time.Duration) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestDebouncer(t *testing.T) {
+	const ms = time.Millisecond
+
+	tests := []struct {
+		name     string
+		opts     ptk.DebounceOptions
+		triggers []time.Duration
+		want     []time.Duration
+	}{
+		{"trailing", ptk.DebounceOptions{Wait: time.Second}, []time.Duration{0, 500 * ms, 1000 * ms}, []time.Duration{2000 * ms}},
+		{"trailing/bursts", ptk.DebounceOptions{Wait: time.Second}, []time.Duration{0, 1500 * ms}, []time.Duration{1000 * ms, 2500 * ms}},
+		{"leading", ptk.DebounceOptions{Wait: time.Second, Edge: ptk.EdgeLeading}, []time.Duration{0, 500 * ms, 1000 * ms, 2500 * ms}, []time.Duration{0, 2500 * ms}},
+		{"both", ptk.DebounceOptions{Wait: time.Second, Edge: ptk.EdgeLeading | ptk.EdgeTrailing}, []time.Duration{0, 500 * ms}, []time.Duration{0, 1500 * ms}},
+		{"both/single", ptk.DebounceOptions{Wait: time.Second, Edge: ptk.EdgeLeading | ptk.EdgeTrailing}, []time.Duration{0}, []time.Duration{0}},
+		{"maxWait", ptk.DebounceOptions{Wait: time.Second, MaxWait: 2500 * ms}, []time.Duration{0, 500 * ms, 1000 * ms, 1500 * ms, 2000 * ms, 2500 * ms}, []time.Duration{2500 * ms, 3500 * ms}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			clk := ptk.NewFakeClock(time.Time{})
+			tc.opts.Clock = clk
+
+			fn, calls := recorder(clk)
+			d := ptk.NewDebouncer(context.Background(), fn, tc.opts)
+			triggerAt(clk, d.Trigger, 10*time.Second, tc.triggers...)
+
+			if got := calls(); !equalDurations(got, tc.want) {
+				t.Fatalf("expected calls at %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestDebouncerFlushStop(t *testing.T) {
+	clk := ptk.NewFakeClock(time.Time{})
+	fn, calls := recorder(clk)
+	d := ptk.NewDebouncer(context.Background(), fn, ptk.DebounceOptions{Wait: time.Second, Clock: clk})
+
+	d.Trigger()
+	if !d.IsPending() {
+		t.Fatal("expected a pending call")
+	}
+
+	clk.Advance(100 * time.Millisecond)
+	d.Flush()
+	if d.IsPending() {
+		t.Fatal("Flush should've called fn")
+	}
+
+	clk.Advance(5 * time.Second)
+	if got := calls(); !equalDurations(got, []time.Duration{100 * time.Millisecond}) {
+		t.Fatalf("unexpected calls: %v", got)
+	}
+
+	d.Trigger()
+	d.Stop()
+	d.Trigger()
+	clk.Advance(5 * time.Second)
+	if got := calls(); len(got) != 1 || d.IsPending() {
+		t.Fatalf("Stop should drop pending calls: %v", got)
+	}
+}
+
+func TestDebouncerCancel(t *testing.T) {
+	var (
+		clk         = ptk.NewFakeClock(time.Time{})
+		ctx, cancel = context.WithCancel(context.Background())
+		fn, calls   = recorder(clk)
+		d           = ptk.NewDebouncer(ctx, fn, ptk.DebounceOptions{Wait: time.Second, Clock: clk})
+		th          = ptk.NewThrottler(ctx, fn, ptk.ThrottleOptions{Interval: time.Second, Edge: ptk.EdgeTrailing, Clock: clk})
+	)
+
+	d.Trigger()
+	th.Trigger()
+	cancel()
+
+	// the ctx's AfterFunc stops them in the background.
+	for i := 0; d.IsPending() || th.IsPending(); i++ {
+		if i == 1000 {
+			t.Fatal("canceling ctx should drop pending calls")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	d.Trigger()
+	th.Trigger()
+	clk.Advance(5 * time.Second)
+	if got := calls(); len(got) != 0 {
+		t.Fatalf("unexpected calls after cancel: %v", got)
+	}
+}
+
+func TestThrottler(t *testing.T) {
+	const ms = time.Millisecond
+
+	tests := []struct {
+		name     string
+		edge     ptk.Edge
+		triggers []time.Duration
+		want     []time.Duration
+	}{
+		{"both", 0, []time.Duration{0, 500 * ms, 1100 * ms}, []time.Duration{0, 1000 * ms, 2000 * ms}},
+		{"both/single", 0, []time.Duration{0}, []time.Duration{0}},
+		{"both/idle", 0, []time.Duration{0, 2500 * ms, 2600 * ms}, []time.Duration{0, 2500 * ms, 3500 * ms}},
+		{"leading", ptk.EdgeLeading, []time.Duration{0, 500 * ms, 1100 * ms, 1500 * ms}, []time.Duration{0, 1100 * ms}},
+		{"trailing", ptk.EdgeTrailing, []time.Duration{0, 500 * ms, 1100 * ms}, []time.Duration{1000 * ms, 2000 * ms}},
+		{"steady", 0, []time.Duration{0, 300 * ms, 600 * ms, 900 * ms, 1200 * ms, 1500 * ms, 1800 * ms, 2100 * ms}, []time.Duration{0, 1000 * ms, 2000 * ms, 3000 * ms}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			clk := ptk.NewFakeClock(time.Time{})
+			fn, calls := recorder(clk)
+			th := ptk.NewThrottler(context.Background(), fn, ptk.ThrottleOptions{Interval: time.Second, Edge: tc.edge, Clock: clk})
+			triggerAt(clk, th.Trigger, 10*time.Second, tc.triggers...)
+
+			got := calls()
+			if !equalDurations(got, tc.want) {
+				t.Fatalf("expected calls at %v, got %v", tc.want, got)
+			}
+
+			for i := 1; i < len(got); i++ {
+				if got[i]-got[i-1] < time.Second {
+					t.Fatalf("calls at %v and %v are less than an interval apart", got[i-1], got[i])
+				}
+			}
+		})
+	}
+}
+
+func TestThrottlerFlushStop(t *testing.T) {
+	clk := ptk.NewFakeClock(time.Time{})
+	fn, calls := recorder(clk)
+	th := ptk.NewThrottler(context.Background(), fn, ptk.ThrottleOptions{Interval: time.Second, Clock: clk})
+
+	th.Trigger()
+	th.Trigger()
+	if !th.IsPending() {
+		t.Fatal("expected a pending call")
+	}
+
+	clk.Advance(100 * time.Millisecond)
+	th.Flush()
+
+	// the flushed call starts a new interval.
+	th.Trigger()
+	clk.Advance(5 * time.Second)
+	if got := calls(); !equalDurations(got, []time.Duration{0, 100 * time.Millisecond, 1100 * time.Millisecond}) {
+		t.Fatalf("unexpected calls: %v", got)
+	}
+
+	th.Trigger()
+	th.Trigger()
+	th.Stop()
+	th.Trigger()
+	clk.Advance(5 * time.Second)
+	if got := calls(); len(got) != 4 || th.IsPending() {
+		t.Fatalf("Stop should drop pending calls: %v", got)
+	}
+}
@@ -6,21 +6,35 @@ import (
 	"time"
 )
 
+// NewTimeoutSet is an alias for NewTimeoutSetWithClock(purgeTimeout, RealClock)
 func NewTimeoutSet(purgeTimeout time.Duration) *TimeoutSet {
+	return NewTimeoutSetWithClock(purgeTimeout, RealClock)
+}
+
+// NewTimeoutSetWithClock returns a new TimeoutSet that uses clk to check and purge expired keys.
+// If purgeTimeout <= 0, expired keys are never purged, but Has and Keys still ignore them.
+func NewTimeoutSetWithClock(purgeTimeout time.Duration, clk Clock) *TimeoutSet {
 	ss := &TimeoutSet{
-		s:    map[string]int64{},
-		done: make(chan struct{}, 1),
+		s:     map[string]int64{},
+		done:  make(chan struct{}, 1),
+		clock: ClockOrReal(clk),
+	}
+
+	if purgeTimeout <= 0 {
+		return ss
 	}
 
+	tick := ss.clock.NewTicker(purgeTimeout)
 	go func() {
+		defer tick.Stop()
 		for {
 			select {
 			case <-ss.done:
 				return
-			case <-time.After(purgeTimeout):
+			case <-tick.C():
 			}
 			ss.mux.Lock()
-			now := time.Now().UnixNano()
+			now := ss.clock.Now().UnixNano()
 			for k, t := range ss.s {
 				if t > -1 && t <= now {
 					delete(ss.s, k)
@@ -34,13 +48,14 @@ func NewTimeoutSet(purgeTimeout time.Duration) *TimeoutSet {
 }
 
 type TimeoutSet struct {
-	s    map[string]int64
-	mux  sync.RWMutex
-	done chan struct{}
+	s     map[string]int64
+	mux   sync.RWMutex
+	done  chan struct{}
+	clock Clock
 }
 
 func (ss *TimeoutSet) Set(key string, to time.Duration) {
-	ts := time.Now().Add(to).UnixNano()
+	ts := ss.clock.Now().Add(to).UnixNano()
 	ss.mux.Lock()
 	ss.s[key] = ts
 	ss.mux.Unlock()
@@ -63,7 +78,7 @@ func (ss *TimeoutSet) Delete(keys ...string) {
 }
 
 func (ss *TimeoutSet) Has(key string) bool {
-	now := time.Now().UnixNano()
+	now := ss.clock.Now().UnixNano()
 	ss.mux.RLock()
 	t := ss.s[key]
 	ss.mux.RUnlock()
@@ -78,7 +93,7 @@ func (ss *TimeoutSet) Len() int {
 }
 
 func (ss *TimeoutSet) Keys() []string {
-	now := time.Now().UnixNano()
+	now := ss.clock.Now().UnixNano()
 	ss.mux.RLock()
 	keys := make([]string, 0, len(ss.s))
 	for k, t := range ss.s {